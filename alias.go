@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path"
+	"sort"
+	"strings"
+)
+
+// aliasTemplate is the page left at an old URL. Browsers follow the refresh
+// at once; search engines follow the canonical link and drop the old URL.
+var aliasTemplate = template.Must(template.New("alias").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="canonical" href="{{.URL}}">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.URL}}">
+</head>
+<body>
+<p>This page has moved to <a href="{{.URL}}">{{.URL}}</a>.</p>
+</body>
+</html>
+`))
+
+// normalizeAlias turns an `aliases:` entry into a root-relative URL path.
+// Paths ending in .html are kept as files; anything else is a directory.
+func normalizeAlias(alias string) (string, error) {
+	alias = strings.TrimSpace(alias)
+	if alias == "" || strings.Contains(alias, "://") || strings.ContainsAny(alias, "?#") {
+		return "", fmt.Errorf("alias %q must be a path on this site, such as /posts/old-name/", alias)
+	}
+	p := path.Clean("/" + alias)
+	if p == "/" {
+		return "", fmt.Errorf("alias %q would replace the home page", alias)
+	}
+	if !strings.HasSuffix(p, ".html") {
+		p += "/"
+	}
+	return p, nil
+}
+
+// generateAliases writes a redirect page at every alias of posts. It runs
+// after everything else is written so that an alias which would replace
+// generated output, or another alias, is reported instead.
+func generateAliases(out *outputWriter, site SiteConfig, posts []*Post) error {
+	claimed := make(map[string]*Post)
+	var problems []string
+	for _, post := range posts {
+		for _, alias := range post.Aliases {
+			file := outputPath(alias)
+			if other, ok := claimed[file]; ok {
+				problems = append(problems, fmt.Sprintf("%s: alias %s is also an alias of %s", post.Source, alias, other.Source))
+				continue
+			}
+			if out.Has(file) {
+				problems = append(problems, fmt.Sprintf("%s: alias %s would replace the generated %s", post.Source, alias, file))
+				continue
+			}
+			claimed[file] = post
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("alias collisions:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	files := make([]string, 0, len(claimed))
+	for file := range claimed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		post := claimed[file]
+		var buf bytes.Buffer
+		err := aliasTemplate.Execute(&buf, struct{ Title, URL string }{post.Title, site.URL + post.URL})
+		if err != nil {
+			return fmt.Errorf("executing alias template for %s: %w", post.Source, err)
+		}
+		if err := out.WriteFile(file, buf.Bytes()); err != nil {
+			return err
+		}
+		fmt.Printf("Generated: %s (alias of %s)\n", file, post.URL)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bundleIndex is the Markdown file that turns a directory into a bundle.
+const bundleIndex = "index.md"
+
+// isBundleIndex reports whether filename, relative to its content
+// directory, is the index of a bundle. An index.md directly in the content
+// directory is an ordinary post or page.
+func isBundleIndex(filename string) bool {
+	return filepath.Base(filename) == bundleIndex && filepath.Dir(filename) != "."
+}
+
+// bundleAssets maps the URL of every file published from the bundles among
+// posts to the file it is copied from. Other Markdown files in a bundle are
+// not published. Relative links and images in a bundle's content that point
+// at its files are rewritten to those URLs, so they keep working wherever
+// the content is shown: listing pages, feeds and the image pipeline.
+func bundleAssets(posts []*Post) (map[string]string, error) {
+	assets := make(map[string]string)
+	for _, post := range posts {
+		if post.Bundle == "" {
+			continue
+		}
+
+		files := make(map[string]bool)
+		err := filepath.WalkDir(post.Bundle, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || strings.HasSuffix(p, ".md") {
+				return nil
+			}
+			rel, err := filepath.Rel(post.Bundle, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			files[rel] = true
+			assets[bundleURL(post)+rel] = p
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		base := &url.URL{Path: bundleURL(post)}
+		post.Content = template.HTML(rewriteURLs(string(post.Content), func(ref *url.URL) (string, bool) {
+			if ref.Scheme != "" || ref.Host != "" || ref.Path == "" || strings.HasPrefix(ref.Path, "/") {
+				return "", false
+			}
+			if !files[path.Clean(ref.Path)] {
+				return "", false
+			}
+			return base.ResolveReference(ref).String(), true
+		}))
+	}
+	return assets, nil
+}
+
+// bundleURL is the directory URL a bundle's files are published under: the
+// post's own URL, or for a post at /posts/my-post.html, /posts/my-post/.
+func bundleURL(post *Post) string {
+	if strings.HasSuffix(post.URL, "/") {
+		return post.URL
+	}
+	return strings.TrimSuffix(post.URL, path.Ext(post.URL)) + "/"
+}
+
+// copyBundleAssets writes the files listed by bundleAssets to the output.
+func copyBundleAssets(out *outputWriter, assets map[string]string) error {
+	urls := make([]string, 0, len(assets))
+	for u := range assets {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	for _, u := range urls {
+		if err := out.CopyFile(assets[u], strings.TrimPrefix(u, "/")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePostBundleIndex(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.md":                 "---\ntitle: Index\ndate: 2026-01-01\n---\nTop level.\n",
+		"notes.txt":                "not an asset",
+		"2026-01-15-trip/index.md": "---\ntitle: Trip\ndate: 2026-01-15\n---\n![map](map.png)\n",
+		"2026-01-15-trip/map.png":  "png",
+		"2026-01-20-plain.md":      "---\ntitle: Plain\ndate: 2026-01-20\n---\nPlain.\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	site := SiteConfig{CacheDir: t.TempDir()}
+	md := newMarkdown(site)
+	cache := loadPostCache(site, true)
+
+	tests := []struct {
+		filename string
+		slug     string
+		bundle   string
+	}{
+		{filename: "index.md", slug: "index"},
+		{filename: "2026-01-20-plain.md", slug: "plain"},
+		{filename: "2026-01-15-trip/index.md", slug: "trip", bundle: filepath.Join(dir, "2026-01-15-trip")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			post, err := parsePost(md, cache, dir, "posts", tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if post.Slug != tt.slug {
+				t.Errorf("Slug = %q, want %q", post.Slug, tt.slug)
+			}
+			if post.Bundle != tt.bundle {
+				t.Errorf("Bundle = %q, want %q", post.Bundle, tt.bundle)
+			}
+		})
+	}
+
+	// The top-level index.md must not turn the content directory into a
+	// bundle that publishes its other files.
+	index, err := parsePost(md, cache, dir, "posts", "index.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.URL = "/posts/index/"
+	assets, err := bundleAssets([]*Post{index})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 0 {
+		t.Errorf("bundleAssets published %v for a top-level index.md", assets)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// cacheDirName is the build cache directory, kept in the source
+	// directory.
+	cacheDirName = ".blogcache"
+
+	// cacheFormat is bumped whenever the cached Post layout or the way
+	// posts are rendered changes, so stale entries are not reused.
+	cacheFormat = "12"
+)
+
+// postCache remembers the parsed form of every post between builds, keyed
+// by a hash of the post's source and of everything else that affects how it
+// renders: the site configuration and the generator version.
+type postCache struct {
+	path    string
+	config  string
+	entries map[string]cachedPost
+
+	mu   sync.Mutex
+	next map[string]cachedPost
+}
+
+type cachedPost struct {
+	Hash string `json:"hash"`
+	Post *Post  `json:"post"`
+}
+
+// loadPostCache opens the post cache for site. A missing or unreadable cache
+// simply starts empty; with force set the previous contents are ignored.
+func loadPostCache(site SiteConfig, force bool) *postCache {
+	config, _ := json.Marshal(site)
+	c := &postCache{
+		path:    filepath.Join(site.CacheDir, "posts.json"),
+		config:  hashBytes([]byte(cacheFormat), []byte(version()), config),
+		entries: make(map[string]cachedPost),
+		next:    make(map[string]cachedPost),
+	}
+	if force {
+		return c
+	}
+
+	if data, err := os.ReadFile(c.path); err == nil {
+		if err := json.Unmarshal(data, &c.entries); err != nil {
+			c.entries = make(map[string]cachedPost)
+		}
+	}
+	return c
+}
+
+func (c *postCache) key(filename string, source []byte) string {
+	return hashBytes([]byte(c.config), []byte(filename), source)
+}
+
+// Get returns the cached post for filename if source is unchanged.
+func (c *postCache) Get(filename string, source []byte) (*Post, bool) {
+	entry, ok := c.entries[filename]
+	if !ok || entry.Hash != c.key(filename, source) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next[filename] = entry
+	return entry.Post, true
+}
+
+func (c *postCache) Put(filename string, source []byte, post *Post) {
+	entry := cachedPost{Hash: c.key(filename, source), Post: post}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next[filename] = entry
+}
+
+// Save writes the entries used by this build, dropping posts that no longer
+// exist.
+func (c *postCache) Save() error {
+	data, err := json.Marshal(c.next)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0o644)
+}
+
+func hashBytes(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// outputWriter writes generated files below dir. Files whose content has not
+// changed are left untouched so their modification times survive a rebuild,
+// and everything written is remembered so files from earlier builds that are
+// no longer produced can be pruned. It is safe for concurrent use.
+type outputWriter struct {
+	dir string
+
+	mu        sync.Mutex
+	written   map[string]bool
+	unchanged int
+}
+
+func newOutputWriter(dir string) *outputWriter {
+	return &outputWriter{dir: dir, written: make(map[string]bool)}
+}
+
+// WriteFile writes data to rel, a path relative to the output directory.
+// Writing the same path twice in one build is an error, since the second
+// file would silently replace the first.
+func (w *outputWriter) WriteFile(rel string, data []byte) error {
+	rel = filepath.Clean(rel)
+	w.mu.Lock()
+	if w.written[rel] {
+		w.mu.Unlock()
+		return fmt.Errorf("%s is written twice; two posts, pages or generated files share a URL", rel)
+	}
+	w.written[rel] = true
+	w.mu.Unlock()
+
+	path := filepath.Join(w.dir, rel)
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		w.mu.Lock()
+		w.unchanged++
+		w.mu.Unlock()
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// CopyFile copies src to rel, a path relative to the output directory.
+func (w *outputWriter) CopyFile(src, rel string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return w.WriteFile(rel, data)
+}
+
+// Has reports whether rel has been written during this build.
+func (w *outputWriter) Has(rel string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written[filepath.Clean(rel)]
+}
+
+func (w *outputWriter) Written() int {
+	return len(w.written) - w.unchanged
+}
+
+func (w *outputWriter) Unchanged() int {
+	return w.unchanged
+}
+
+// Prune removes files below the output directory that were not written by
+// this build, along with any directories left empty, and reports how many
+// files it removed.
+func (w *outputWriter) Prune() (int, error) {
+	var stale, dirs []string
+	err := filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(w.dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel != "." {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if !w.written[rel] {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return 0, err
+		}
+	}
+
+	// Deepest directories first so parents empty out before they are tried.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			os.Remove(dir)
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// externalCacheTTL is how long a working external link is trusted before
+// `blog check --external` requests it again. Failures are never cached.
+const externalCacheTTL = 24 * time.Hour
+
+// assetAttrs lists, per element, the attributes that load something the page
+// needs rather than link somewhere the reader may go.
+var assetAttrs = map[string][]string{
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"script": {"src"},
+	"link":   {"href"},
+	"iframe": {"src"},
+	"audio":  {"src"},
+	"video":  {"src", "poster"},
+	"track":  {"src"},
+	"embed":  {"src"},
+}
+
+// scannedPage is an HTML file from the output directory.
+type scannedPage struct {
+	file string          // path below the output directory, slash-separated
+	ids  map[string]bool // fragment targets: id and <a name> values
+	refs []pageRef
+}
+
+type pageRef struct {
+	url   string
+	asset bool
+	line  int
+}
+
+// linkProblem is a reference that does not resolve, located both in the
+// generated file and, when known, in the source it was built from.
+type linkProblem struct {
+	source string
+	line   int
+	file   string
+	fLine  int
+	msg    string
+}
+
+func (p linkProblem) String() string {
+	generated := fmt.Sprintf("%s:%d", p.file, p.fLine)
+	switch {
+	case p.source != "" && p.line > 0:
+		return fmt.Sprintf("%s:%d: %s (%s)", p.source, p.line, p.msg, generated)
+	case p.source != "":
+		return fmt.Sprintf("%s: %s (%s)", p.source, p.msg, generated)
+	}
+	return fmt.Sprintf("%s: %s", generated, p.msg)
+}
+
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	external := fs.Bool("external", false, "Also check links to other sites")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "Number of external links to check at once")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout for each external request")
+	var opts siteOptions
+	siteFlags(fs, &opts)
+	fs.Parse(args)
+
+	site, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	siteURL, err := url.Parse(site.URL)
+	if err != nil {
+		return fmt.Errorf("%s: parsing url: %w", opts.configPath(), err)
+	}
+
+	files, pages, err := scanOutput(site.OutputDir)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", site.OutputDir, err)
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("no HTML pages in %s; run `blog generate` first", site.OutputDir)
+	}
+	sources, err := contentSources(site)
+	if err != nil {
+		return err
+	}
+
+	var problems []linkProblem
+	report := func(page *scannedPage, ref pageRef, msg string) {
+		p := linkProblem{file: filepath.Join(site.OutputDir, page.file), fLine: ref.line, msg: msg}
+		if source, ok := sources[pageURL(page.file)]; ok {
+			p.source = source
+			p.line = sourceLine(source, ref.url)
+		}
+		problems = append(problems, p)
+	}
+
+	type externalRef struct {
+		page *scannedPage
+		ref  pageRef
+	}
+	externalRefs := make(map[string][]externalRef)
+	checked := 0
+
+	for _, page := range pages {
+		base := &url.URL{Path: pageURL(page.file)}
+		for _, ref := range page.refs {
+			u, err := url.Parse(ref.url)
+			if err != nil {
+				report(page, ref, fmt.Sprintf("malformed URL %q", ref.url))
+				continue
+			}
+			if u.Scheme == "http" || u.Scheme == "https" {
+				if !strings.EqualFold(u.Host, siteURL.Host) {
+					target := *u
+					target.Fragment = ""
+					externalRefs[target.String()] = append(externalRefs[target.String()], externalRef{page, ref})
+					continue
+				}
+				u = &url.URL{Path: u.Path, RawQuery: u.RawQuery, Fragment: u.Fragment}
+			} else if u.Scheme != "" || u.Host != "" {
+				continue // mailto:, tel: and the like
+			}
+			checked++
+
+			resolved := base.ResolveReference(u)
+			target, ok := resolveOutputPath(files, resolved.Path)
+			if !ok {
+				what := "broken link"
+				if ref.asset {
+					what = "missing asset"
+				}
+				report(page, ref, fmt.Sprintf("%s %s", what, ref.url))
+				continue
+			}
+			if resolved.Fragment == "" || resolved.Fragment == "top" {
+				continue
+			}
+			if targetPage, ok := pages[target]; ok && !targetPage.ids[resolved.Fragment] {
+				report(page, ref, fmt.Sprintf("missing anchor %s", ref.url))
+			}
+		}
+	}
+
+	if *external && len(externalRefs) > 0 {
+		urls := make([]string, 0, len(externalRefs))
+		for u := range externalRefs {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+
+		client := &http.Client{Timeout: *timeout}
+		results, err := checkExternalLinks(client, loadLinkCache(site.CacheDir), urls, *jobs)
+		if err != nil {
+			return err
+		}
+
+		for i, u := range urls {
+			if results[i] == nil {
+				continue
+			}
+			for _, r := range externalRefs[u] {
+				report(r.page, r.ref, fmt.Sprintf("external link %s: %v", r.ref.url, results[i]))
+			}
+		}
+		fmt.Printf("Checked %d pages, %d internal and %d external links\n", len(pages), checked, len(urls))
+	} else {
+		fmt.Printf("Checked %d pages, %d internal links (%d external links skipped; use --external)\n", len(pages), checked, len(externalRefs))
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].String() < problems[j].String()
+	})
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return fmt.Errorf("found %d problem(s)", len(problems))
+}
+
+// scanOutput lists every file below dir and parses the HTML pages among
+// them, both keyed by slash-separated path relative to dir.
+func scanOutput(dir string) (map[string]bool, map[string]*scannedPage, error) {
+	files := make(map[string]bool)
+	pages := make(map[string]*scannedPage)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		files[rel] = true
+		if !strings.HasSuffix(rel, ".html") {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		pages[rel] = scanPage(rel, data)
+		return nil
+	})
+	return files, pages, err
+}
+
+// scanPage collects the anchors a page defines and the URLs it references,
+// with the line each reference is on.
+func scanPage(file string, data []byte) *scannedPage {
+	page := &scannedPage{file: file, ids: make(map[string]bool)}
+	z := nethtml.NewTokenizer(bytes.NewReader(data))
+	line := 1
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			return page
+		}
+		tokenLine := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+		if tt != nethtml.StartTagToken && tt != nethtml.SelfClosingTagToken {
+			continue
+		}
+
+		tok := z.Token()
+		for _, attr := range tok.Attr {
+			switch {
+			case attr.Key == "id" || (attr.Key == "name" && tok.Data == "a"):
+				page.ids[attr.Val] = true
+			case attr.Key == "href" && (tok.Data == "a" || tok.Data == "area"):
+				page.refs = append(page.refs, pageRef{url: attr.Val, line: tokenLine})
+			case isAssetAttr(tok.Data, attr.Key):
+				urls := []string{attr.Val}
+				if attr.Key == "srcset" {
+					urls = nil
+					for _, c := range strings.Split(attr.Val, ",") {
+						if fields := strings.Fields(c); len(fields) > 0 {
+							urls = append(urls, fields[0])
+						}
+					}
+				}
+				for _, u := range urls {
+					page.refs = append(page.refs, pageRef{url: u, asset: true, line: tokenLine})
+				}
+			}
+		}
+	}
+}
+
+func isAssetAttr(element, attr string) bool {
+	for _, a := range assetAttrs[element] {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// pageURL is the URL path an output file is served at.
+func pageURL(file string) string {
+	return "/" + strings.TrimSuffix(file, "index.html")
+}
+
+// resolveOutputPath finds the output file a URL path is served from, the
+// way a static file server would: directories serve their index.html.
+func resolveOutputPath(files map[string]bool, urlPath string) (string, bool) {
+	rel := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if strings.HasSuffix(urlPath, "/") || rel == "" {
+		rel = path.Join(rel, "index.html")
+		return rel, files[rel]
+	}
+	if files[rel] {
+		return rel, true
+	}
+	index := path.Join(rel, "index.html")
+	return index, files[index]
+}
+
+// contentSources maps the URL of every post and page, drafts included, to
+// its Markdown source, so problems can be reported where they can be fixed.
+func contentSources(site SiteConfig) (map[string]string, error) {
+	md := newMarkdown(site)
+	cache := loadPostCache(site, false)
+	opts := buildOptions{Jobs: runtime.NumCPU(), Drafts: true}
+
+	posts, err := parsePosts(md, cache, site, opts)
+	if err != nil {
+		return nil, fmt.Errorf("parsing posts: %w", err)
+	}
+	pages, err := parsePages(md, cache, site, opts)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pages: %w", err)
+	}
+
+	sources := make(map[string]string)
+	for _, post := range append(posts, pages...) {
+		sources[post.URL] = post.Source
+	}
+	return sources, nil
+}
+
+// sourceLine guesses the line of source that produced ref by looking for
+// the reference as written, then for its last path segment, which survives
+// the rewriting of bundle and cross-post links. It returns 0 when neither is
+// found.
+func sourceLine(source, ref string) int {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return 0
+	}
+	needles := []string{ref}
+	if u, err := url.Parse(ref); err == nil {
+		if base := path.Base(strings.TrimSuffix(u.Path, "/")); base != "." && base != "/" {
+			needles = append(needles, base)
+		}
+	}
+	lines := strings.Split(string(data), "\n")
+	for _, needle := range needles {
+		for i, l := range lines {
+			if strings.Contains(l, needle) {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// checkExternalLinks requests urls, jobs at a time, skipping those cache
+// says worked recently. It returns the problem with each URL, or nil.
+func checkExternalLinks(client *http.Client, cache *linkCache, urls []string, jobs int) ([]error, error) {
+	results := make([]error, len(urls))
+	runJobs(jobs, len(urls), func(i int, log io.Writer) error {
+		if cache.fresh(urls[i]) {
+			return nil
+		}
+		results[i] = checkExternal(client, urls[i])
+		if results[i] == nil {
+			cache.mark(urls[i])
+		}
+		return nil
+	})
+	if err := cache.save(); err != nil {
+		return nil, fmt.Errorf("saving link cache: %w", err)
+	}
+	return results, nil
+}
+
+// checkExternal requests u and reports an error for anything but a
+// successful response. HEAD is tried first; servers that refuse it get a
+// GET.
+func checkExternal(client *http.Client, u string) error {
+	resp, err := client.Head(u)
+	if err == nil {
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusMethodNotAllowed, http.StatusForbidden, http.StatusNotImplemented:
+			resp, err = client.Get(u)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// linkCache remembers when external links last worked, so repeated checks
+// do not hammer the same sites.
+type linkCache struct {
+	path    string
+	checked map[string]time.Time
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func loadLinkCache(dir string) *linkCache {
+	c := &linkCache{
+		path:    filepath.Join(dir, "links.json"),
+		checked: make(map[string]time.Time),
+		next:    make(map[string]time.Time),
+	}
+	if data, err := os.ReadFile(c.path); err == nil {
+		if err := json.Unmarshal(data, &c.checked); err != nil {
+			c.checked = make(map[string]time.Time)
+		}
+	}
+	for u, t := range c.checked {
+		if time.Since(t) < externalCacheTTL {
+			c.next[u] = t
+		}
+	}
+	return c
+}
+
+// fresh reports whether u worked recently enough to skip. It only reads
+// entries loaded from disk, so it is safe to call concurrently with mark
+// for other URLs.
+func (c *linkCache) fresh(u string) bool {
+	t, ok := c.checked[u]
+	return ok && time.Since(t) < externalCacheTTL
+}
+
+func (c *linkCache) mark(u string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next[u] = time.Now()
+}
+
+func (c *linkCache) save() error {
+	data, err := json.Marshal(c.next)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(c.path, data)
+}
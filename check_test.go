@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// linkServer stands in for other sites. Each path answers differently, and
+// every request is counted by method and path.
+func linkServer(t *testing.T) (*httptest.Server, func(method, path string) int) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/ok":
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/head-forbidden":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	count := func(method, path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[method+" "+path]
+	}
+	return srv, count
+}
+
+func TestCheckExternal(t *testing.T) {
+	srv, count := linkServer(t)
+
+	tests := []struct {
+		path    string
+		wantErr string
+		gets    int
+	}{
+		{path: "/ok"},
+		{path: "/no-head", gets: 1},
+		{path: "/head-forbidden", gets: 1},
+		{path: "/gone", wantErr: "404"},
+		{path: "/broken", wantErr: "500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := checkExternal(srv.Client(), srv.URL+tt.path)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkExternal() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("checkExternal() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if got := count(http.MethodHead, tt.path); got != 1 {
+				t.Errorf("HEAD requests = %d, want 1", got)
+			}
+			if got := count(http.MethodGet, tt.path); got != tt.gets {
+				t.Errorf("GET requests = %d, want %d", got, tt.gets)
+			}
+		})
+	}
+}
+
+func TestCheckExternalLinksCachesSuccesses(t *testing.T) {
+	srv, count := linkServer(t)
+	dir := t.TempDir()
+	urls := []string{srv.URL + "/gone", srv.URL + "/no-head", srv.URL + "/ok"}
+
+	for run := 1; run <= 2; run++ {
+		results, err := checkExternalLinks(srv.Client(), loadLinkCache(dir), urls, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0] == nil {
+			t.Errorf("run %d: %s reported as working", run, urls[0])
+		}
+		for i, err := range results[1:] {
+			if err != nil {
+				t.Errorf("run %d: %s: %v", run, urls[i+1], err)
+			}
+		}
+	}
+
+	// Working links are only requested by the first run; the broken one is
+	// requested again.
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodHead, "/ok", 1},
+		{http.MethodHead, "/no-head", 1},
+		{http.MethodGet, "/no-head", 1},
+		{http.MethodHead, "/gone", 2},
+	} {
+		if got := count(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s requested %d times, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
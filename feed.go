@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var urlAttrPattern = regexp.MustCompile(`(\s(?:href|src|srcset))="([^"]*)"`)
+
+// absoluteURLs rewrites href, src and srcset attributes in rendered HTML so
+// that root-relative and relative references resolve against base, the
+// absolute URL of the page the HTML came from. Feed readers display content
+// away from the site, where relative links would point nowhere.
+func absoluteURLs(content, base string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return content
+	}
+	return rewriteURLs(content, func(ref *url.URL) (string, bool) {
+		return baseURL.ResolveReference(ref).String(), true
+	})
+}
+
+// rewriteURLs replaces every URL in the href, src and srcset attributes of
+// content with what fn returns for it, leaving URLs alone when fn returns
+// false or the URL does not parse.
+func rewriteURLs(content string, fn func(ref *url.URL) (string, bool)) string {
+	rewrite := func(s string) string {
+		ref, err := url.Parse(s)
+		if err != nil {
+			return s
+		}
+		if replaced, ok := fn(ref); ok {
+			return replaced
+		}
+		return s
+	}
+	return urlAttrPattern.ReplaceAllStringFunc(content, func(m string) string {
+		parts := urlAttrPattern.FindStringSubmatch(m)
+		original := html.UnescapeString(parts[2])
+		val := original
+
+		if strings.HasSuffix(parts[1], "srcset") {
+			candidates := strings.Split(val, ",")
+			for i, c := range candidates {
+				fields := strings.Fields(c)
+				if len(fields) > 0 {
+					fields[0] = rewrite(fields[0])
+					candidates[i] = strings.Join(fields, " ")
+				}
+			}
+			val = strings.Join(candidates, ", ")
+		} else {
+			val = rewrite(val)
+		}
+		if val == original {
+			return m
+		}
+		return parts[1] + `="` + html.EscapeString(val) + `"`
+	})
+}
+
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Authors     []JSONAuthor   `json:"authors,omitempty"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+type JSONAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type JSONFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	Summary       string   `json:"summary,omitempty"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+type AtomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string      `xml:"title"`
+	Subtitle  string      `xml:"subtitle,omitempty"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Links     []AtomLink  `xml:"link"`
+	Author    AtomPerson  `xml:"author"`
+	Generator string      `xml:"generator"`
+	Entries   []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type AtomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type AtomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Link       AtomLink       `xml:"link"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []AtomCategory `xml:"category"`
+}
+
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func generateAtomFeed(out *outputWriter, site SiteConfig, posts []*Post) error {
+	author := site.Author
+	if author == "" {
+		author = site.Title
+	}
+
+	var entries []AtomEntry
+	for _, post := range feedPosts(site, posts) {
+		entry := AtomEntry{
+			Title:     post.Title,
+			ID:        site.URL + post.URL,
+			Updated:   post.Date.Format(time.RFC3339),
+			Published: post.Date.Format(time.RFC3339),
+			Link:      AtomLink{Href: site.URL + post.URL, Rel: "alternate", Type: "text/html"},
+			Summary:   post.Description,
+		}
+		for _, tag := range post.Tags {
+			entry.Categories = append(entry.Categories, AtomCategory{Term: tag})
+		}
+		entries = append(entries, entry)
+	}
+
+	// Atom requires an updated timestamp even for an empty feed. A fixed
+	// one keeps the file unchanged, and so unwritten, between builds.
+	var updated time.Time
+	if len(posts) > 0 {
+		updated = posts[0].Date
+	}
+
+	feed := AtomFeed{
+		Title:    site.Title,
+		Subtitle: site.Description,
+		ID:       site.URL + "/",
+		Updated:  updated.Format(time.RFC3339),
+		Links: []AtomLink{
+			{Href: site.URL + "/atom.xml", Rel: "self", Type: "application/atom+xml"},
+			{Href: site.URL + "/", Rel: "alternate", Type: "text/html"},
+		},
+		Author:    AtomPerson{Name: author, URI: site.URL},
+		Generator: "blog " + version(),
+		Entries:   entries,
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return fmt.Errorf("encoding Atom: %w", err)
+	}
+	buf.WriteString("\n")
+
+	if err := out.WriteFile("atom.xml", buf.Bytes()); err != nil {
+		return err
+	}
+
+	fmt.Println("Generated: atom.xml")
+	return nil
+}
+
+func generateJSONFeed(out *outputWriter, site SiteConfig, posts []*Post) error {
+	items := []JSONFeedItem{}
+	for _, post := range feedPosts(site, posts) {
+		items = append(items, JSONFeedItem{
+			ID:            site.URL + post.URL,
+			URL:           site.URL + post.URL,
+			Title:         post.Title,
+			ContentHTML:   string(post.Content),
+			Summary:       post.Description,
+			DatePublished: post.Date.Format(time.RFC3339),
+			Tags:          post.Tags,
+		})
+	}
+
+	feed := JSONFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       site.Title,
+		HomePageURL: site.URL + "/",
+		FeedURL:     site.URL + "/feed.json",
+		Description: site.Description,
+		Items:       items,
+	}
+	if site.Author != "" {
+		feed.Authors = []JSONAuthor{{Name: site.Author, URL: site.URL}}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return fmt.Errorf("encoding JSON feed: %w", err)
+	}
+
+	if err := out.WriteFile("feed.json", buf.Bytes()); err != nil {
+		return err
+	}
+
+	fmt.Println("Generated: feed.json")
+	return nil
+}
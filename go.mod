@@ -3,8 +3,13 @@ module github.com/michaelwinser/blog
 go 1.23
 
 require (
+	github.com/alecthomas/chroma/v2 v2.14.0
 	github.com/yuin/goldmark v1.7.8
+	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
 	github.com/yuin/goldmark-meta v1.1.0
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.26.0
+	gopkg.in/yaml.v2 v2.3.0
 )
 
-require gopkg.in/yaml.v2 v2.3.0 // indirect
+require github.com/dlclark/regexp2 v1.11.0 // indirect
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	nethtml "golang.org/x/net/html"
+)
+
+const (
+	defaultImageQuality = 80
+	defaultImageSizes   = "100vw"
+
+	// placeholderWidth is the width of the blurred preview inlined as the
+	// image's background while the real image loads.
+	placeholderWidth = 16
+)
+
+// ImageConfig controls the responsive image pipeline, which is off unless
+// widths are configured.
+type ImageConfig struct {
+	Widths      []int  `yaml:"widths"`
+	Quality     int    `yaml:"quality"`
+	Sizes       string `yaml:"sizes"`
+	Placeholder bool   `yaml:"placeholder"`
+}
+
+var imgTagPattern = regexp.MustCompile(`<img\s[^>]*>`)
+
+// imageInfo describes a local image and the resized copies made of it.
+type imageInfo struct {
+	Width       int
+	Height      int
+	Variants    []imageVariant
+	Placeholder string
+
+	// cached lists the build cache files holding the resized copies.
+	cached []string
+}
+
+type imageVariant struct {
+	URL   string
+	Width int
+}
+
+// staticAssets maps the URL path of every file under staticDir to the file
+// it is copied from.
+func staticAssets(staticDir string) (map[string]string, error) {
+	assets := make(map[string]string)
+	err := filepath.WalkDir(staticDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(staticDir, p)
+		if err != nil {
+			return err
+		}
+		assets["/"+filepath.ToSlash(rel)] = p
+		return nil
+	})
+	return assets, err
+}
+
+// processImages rewrites the <img> tags in posts that point at local images
+// so browsers can pick a suitable size: the tag gets the image's dimensions,
+// a srcset of resized copies, sizes and loading="lazy". Resized copies are
+// written next to the original and kept in the build cache, so an image is
+// only decoded again when it changes. Images that are missing, remote or
+// already carry a srcset are left alone.
+func processImages(out *outputWriter, site SiteConfig, posts []*Post, assets map[string]string, jobs int, force bool) error {
+	if len(site.Images.Widths) == 0 {
+		return nil
+	}
+
+	var sources []string
+	seen := make(map[string]bool)
+	for _, post := range posts {
+		for _, tag := range imgTagPattern.FindAllString(string(post.Content), -1) {
+			src, ok := imageSource(tag)
+			if !ok || seen[src] {
+				continue
+			}
+			seen[src] = true
+			if _, ok := assets[src]; ok {
+				sources = append(sources, src)
+			}
+		}
+	}
+	sort.Strings(sources)
+
+	infos := make([]*imageInfo, len(sources))
+	err := runJobs(jobs, len(sources), func(i int, log io.Writer) error {
+		info, err := prepareImage(out, site.Images, site.CacheDir, sources[i], assets[sources[i]], force, log)
+		if err != nil {
+			return fmt.Errorf("processing %s: %w", assets[sources[i]], err)
+		}
+		infos[i] = info
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	byURL := make(map[string]*imageInfo)
+	used := make(map[string]bool)
+	for i, src := range sources {
+		if infos[i] != nil {
+			byURL[src] = infos[i]
+			for _, f := range infos[i].cached {
+				used[f] = true
+			}
+		}
+	}
+	if err := pruneImageCache(site.CacheDir, used); err != nil {
+		return fmt.Errorf("pruning image cache: %w", err)
+	}
+
+	for _, post := range posts {
+		content := imgTagPattern.ReplaceAllStringFunc(string(post.Content), func(tag string) string {
+			src, ok := imageSource(tag)
+			if !ok || byURL[src] == nil {
+				return tag
+			}
+			return responsiveImgTag(tag, byURL[src], site.Images)
+		})
+		post.Content = template.HTML(content)
+	}
+	return nil
+}
+
+// imageSource returns the URL path an <img> tag loads, if it is a local
+// image without a srcset of its own.
+func imageSource(tag string) (string, bool) {
+	z := nethtml.NewTokenizer(strings.NewReader(tag))
+	z.Next()
+	var src string
+	for _, attr := range z.Token().Attr {
+		switch attr.Key {
+		case "srcset":
+			return "", false
+		case "src":
+			src = attr.Val
+		}
+	}
+	u, err := url.Parse(src)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// prepareImage reads the dimensions of the image at src, copied from file,
+// and writes a resized copy for every configured width narrower than the
+// original, keeping the copies under cacheDir. GIFs and WebP images are
+// only measured.
+func prepareImage(out *outputWriter, cfg ImageConfig, cacheDir, src, file string, force bool, log io.Writer) (*imageInfo, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// Not an image Go can read, such as SVG; leave the tag alone.
+		return nil, nil
+	}
+	info := &imageInfo{Width: config.Width, Height: config.Height}
+	if format != "jpeg" && format != "png" {
+		return info, nil
+	}
+
+	var decoded image.Image
+	decode := func() (image.Image, error) {
+		if decoded != nil {
+			return decoded, nil
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		decoded = img
+		return img, err
+	}
+
+	ext := path.Ext(src)
+	base := strings.TrimSuffix(src, ext)
+	var resized []string
+	for _, width := range cfg.Widths {
+		if width >= config.Width {
+			continue
+		}
+		key := hashBytes(data, []byte(strconv.Itoa(width)), []byte(strconv.Itoa(cfg.Quality)))
+		// The hash in the name keeps variants from colliding with files of
+		// the site's own, such as a photo-480w.jpg next to photo.jpg.
+		variant := fmt.Sprintf("%s-%dw.%s%s", base, width, key[:8], ext)
+		cached := filepath.Join(cacheDir, "images", key+ext)
+
+		scaled, err := os.ReadFile(cached)
+		if err != nil || force {
+			img, err := decode()
+			if err != nil {
+				return nil, err
+			}
+			if scaled, err = encodeImage(resizeImage(img, width), format, cfg.Quality); err != nil {
+				return nil, err
+			}
+			if err := writeCacheFile(cached, scaled); err != nil {
+				return nil, err
+			}
+			resized = append(resized, strconv.Itoa(width))
+		}
+
+		info.cached = append(info.cached, cached)
+
+		if err := out.WriteFile(strings.TrimPrefix(variant, "/"), scaled); err != nil {
+			return nil, err
+		}
+		info.Variants = append(info.Variants, imageVariant{URL: variant, Width: width})
+	}
+	if len(resized) > 0 {
+		fmt.Fprintf(log, "Resized: %s to %spx wide\n", file, strings.Join(resized, ", "))
+	}
+
+	if cfg.Placeholder {
+		cached := filepath.Join(cacheDir, "images", hashBytes(data, []byte("placeholder"))+".jpg")
+		preview, err := os.ReadFile(cached)
+		if err != nil || force {
+			img, err := decode()
+			if err != nil {
+				return nil, err
+			}
+			if preview, err = encodeImage(resizeImage(img, placeholderWidth), "jpeg", 50); err != nil {
+				return nil, err
+			}
+			if err := writeCacheFile(cached, preview); err != nil {
+				return nil, err
+			}
+		}
+		info.cached = append(info.cached, cached)
+		info.Placeholder = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(preview)
+	}
+	return info, nil
+}
+
+// resizeImage scales img to width, keeping its aspect ratio.
+func resizeImage(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("cannot encode %s images", format)
+	}
+	return buf.Bytes(), err
+}
+
+// pruneImageCache deletes cached copies of images no post uses any more.
+func pruneImageCache(cacheDir string, used map[string]bool) error {
+	dir := filepath.Join(cacheDir, "images")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if !used[p] {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+func writeCacheFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// responsiveImgTag adds what info knows about an image to its <img> tag.
+// Attributes the author set explicitly win.
+func responsiveImgTag(tag string, info *imageInfo, cfg ImageConfig) string {
+	z := nethtml.NewTokenizer(strings.NewReader(tag))
+	z.Next()
+	tok := z.Token()
+
+	has := make(map[string]bool)
+	for _, attr := range tok.Attr {
+		has[attr.Key] = true
+	}
+	add := func(key, val string) {
+		if !has[key] {
+			tok.Attr = append(tok.Attr, nethtml.Attribute{Key: key, Val: val})
+			has[key] = true
+		}
+	}
+
+	if !has["width"] && !has["height"] {
+		add("width", strconv.Itoa(info.Width))
+		add("height", strconv.Itoa(info.Height))
+	}
+	if len(info.Variants) > 0 {
+		var srcset []string
+		for _, v := range info.Variants {
+			srcset = append(srcset, fmt.Sprintf("%s %dw", escapePath(v.URL), v.Width))
+		}
+		src, _ := imageSource(tag)
+		srcset = append(srcset, fmt.Sprintf("%s %dw", escapePath(src), info.Width))
+		add("srcset", strings.Join(srcset, ", "))
+		add("sizes", cfg.Sizes)
+	}
+	add("loading", "lazy")
+	if info.Placeholder != "" {
+		add("style", "background-size:cover;background-image:url("+info.Placeholder+")")
+	}
+	return tok.String()
+}
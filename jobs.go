@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// runJobs calls fn for every index in [0, n) on up to jobs goroutines. Each
+// call logs to its own buffer; once all calls finish the buffers are copied
+// to stdout in index order, stopping after the lowest failing index, whose
+// error is returned. Output and failures therefore do not depend on how the
+// calls were scheduled.
+func runJobs(jobs, n int, fn func(i int, log io.Writer) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	logs := make([]bytes.Buffer, n)
+	errs := make([]error, n)
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = fn(i, &logs[i])
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i := range logs {
+		os.Stdout.Write(logs[i].Bytes())
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// refScheme marks a link to another post or page by name, wherever its
+// file lives: [text](ref:2025-03-01-other-post).
+const refScheme = "ref"
+
+// resolvePostLinks rewrites links to Markdown sources into the URLs of the
+// posts and pages built from them. A relative link ending in .md names a
+// file relative to the linking file, as in an editor or repository browser;
+// a ref: link names a file by its base name, or by its path from the source
+// directory when it contains a slash. Either may carry a #fragment. Links
+// whose target is not part of this build are reported together as one
+// error.
+func resolvePostLinks(site SiteConfig, posts []*Post) error {
+	// Sources are keyed by their path from the source directory, the form
+	// ref: links use, however the site was located on the command line.
+	sources := make(map[*Post]string)
+	bySource := make(map[string]*Post)
+	byName := make(map[string][]*Post)
+	for _, post := range posts {
+		source := post.Source
+		if rel, err := filepath.Rel(site.SourceDir, source); err == nil {
+			source = rel
+		}
+		source = filepath.ToSlash(source)
+		sources[post] = source
+		// A bundle can be named by its directory or its index file.
+		bySource[strings.TrimSuffix(source, ".md")] = post
+		stem := sourceStem(source, post.Bundle != "")
+		bySource[stem] = post
+		byName[path.Base(stem)] = append(byName[path.Base(stem)], post)
+	}
+
+	var problems []string
+	for _, post := range posts {
+		from := sources[post]
+		content := rewriteURLs(string(post.Content), func(ref *url.URL) (string, bool) {
+			var target *Post
+			switch {
+			case ref.Scheme == refScheme:
+				name := strings.TrimSuffix(ref.Opaque, ".md")
+				if strings.Contains(name, "/") {
+					target = bySource[path.Clean(name)]
+				} else if matches := byName[name]; len(matches) > 1 {
+					problems = append(problems, fmt.Sprintf("%s: ref:%s is ambiguous; use its path, such as ref:%s", post.Source, ref.Opaque, sourceStem(sources[matches[0]], matches[0].Bundle != "")))
+					return "", false
+				} else if len(matches) == 1 {
+					target = matches[0]
+				}
+				if target == nil {
+					problems = append(problems, fmt.Sprintf("%s: ref:%s does not match any post or page in this build", post.Source, ref.Opaque))
+					return "", false
+				}
+
+			case ref.Scheme == "" && ref.Host == "" && strings.HasSuffix(ref.Path, ".md") && !strings.HasPrefix(ref.Path, "/"):
+				stem := strings.TrimSuffix(path.Join(path.Dir(from), ref.Path), ".md")
+				if target = bySource[stem]; target == nil {
+					problems = append(problems, fmt.Sprintf("%s: link to %s does not match any post or page in this build", post.Source, ref.Path))
+					return "", false
+				}
+
+			default:
+				return "", false
+			}
+
+			resolved := target.URL
+			if ref.Fragment != "" {
+				resolved += "#" + ref.EscapedFragment()
+			}
+			return resolved, true
+		})
+		post.Content = template.HTML(content)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("broken links to posts:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// sourceStem identifies content by its slash-separated source path without
+// the .md extension; a bundle is identified by its directory.
+func sourceStem(source string, bundle bool) string {
+	if bundle {
+		return path.Dir(source)
+	}
+	return strings.TrimSuffix(source, ".md")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// frontmatterKeys lists every frontmatter key the generator reads, with a
+// check of the value's type.
+var frontmatterKeys = map[string]func(interface{}) error{
+	"title":       wantString,
+	"date":        wantDate,
+	"expires":     wantDate,
+	"description": wantString,
+	"tags":        wantStringList,
+	"categories":  wantStringList,
+	"draft":       wantBool,
+	"sitemap":     wantBool,
+	"toc":         wantBool,
+	"menu":        wantBool,
+	"weight":      wantInt,
+	"unsafe":      wantBool,
+	"slug":        wantString,
+	"aliases":     wantStringList,
+}
+
+// lintProblem is something wrong with a content file's frontmatter.
+type lintProblem struct {
+	source string
+	line   int
+	msg    string
+}
+
+func (p lintProblem) String() string {
+	if p.line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.source, p.line, p.msg)
+	}
+	return fmt.Sprintf("%s: %s", p.source, p.msg)
+}
+
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	var opts siteOptions
+	siteFlags(fs, &opts)
+	fs.Parse(args)
+
+	site, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	problems, err := lintContent(site)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return fmt.Errorf("found %d problem(s)", len(problems))
+}
+
+// lintError describes problems as a single error, for strict builds.
+func lintError(problems []lintProblem) error {
+	lines := make([]string, len(problems))
+	for i, p := range problems {
+		lines[i] = p.String()
+	}
+	return fmt.Errorf("found %d frontmatter problem(s) (strict mode):\n  %s", len(problems), strings.Join(lines, "\n  "))
+}
+
+// lintContent checks the frontmatter of every post and page, drafts
+// included, and looks for files or aliases that would be published at the
+// same URL.
+func lintContent(site SiteConfig) ([]lintProblem, error) {
+	var problems []lintProblem
+	published := make(map[string]string) // output file to the first source using it
+	claim := func(url, path, how string) {
+		file := outputPath(url)
+		if isGeneratedPath(file) {
+			problems = append(problems, lintProblem{source: path, msg: fmt.Sprintf("%s %s, which the generator writes itself", how, url)})
+			return
+		}
+		if other, ok := published[file]; ok {
+			problems = append(problems, lintProblem{source: path, msg: fmt.Sprintf("%s %s, which %s already uses", how, url, other)})
+			return
+		}
+		published[file] = path
+	}
+
+	var tagged []*Post // frontmatter terms, to look for terms sharing a URL
+	for _, dir := range []struct{ path, section string }{{site.ContentDir, "posts"}, {site.PagesDir, "pages"}} {
+		if _, err := os.Stat(dir.path); os.IsNotExist(err) && dir.section == "pages" {
+			continue
+		}
+		filenames, err := contentFiles(dir.path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, filename := range filenames {
+			path := filepath.Join(dir.path, filename)
+			source, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			meta, found := lintFrontmatter(path, dir.section, source)
+			problems = append(problems, found...)
+			tagged = append(tagged, &Post{Source: path, Tags: stringList(meta["tags"]), Categories: stringList(meta["categories"])})
+
+			slug := deriveSlug(filename)
+			if s, ok := meta["slug"].(string); ok && strings.TrimSpace(s) != "" {
+				slug = strings.TrimSpace(s)
+			}
+			date, _ := parseDate(meta["date"])
+			if url, err := permalink(site, dir.section, slug, date); err != nil {
+				problems = append(problems, lintProblem{source: path, msg: err.Error()})
+			} else {
+				claim(url, path, "publishes to")
+			}
+
+			for _, alias := range stringList(meta["aliases"]) {
+				p, err := normalizeAlias(alias)
+				if err != nil {
+					problems = append(problems, lintProblem{source: path, msg: err.Error()})
+					continue
+				}
+				claim(p, path, "has alias")
+			}
+		}
+	}
+	_, clashes := collectTaxonomies(tagged)
+	return append(problems, clashes...), nil
+}
+
+var frontmatterDelimiter = regexp.MustCompile(`^(-{3}|\.{3})\s*$`)
+
+// lintFrontmatter checks one file's frontmatter: that it parses, that every
+// key is known and holds the right type, and that required keys are there.
+// It also returns the parsed frontmatter.
+func lintFrontmatter(path, section string, source []byte) (map[string]interface{}, []lintProblem) {
+	lines := strings.Split(string(source), "\n")
+	end := -1
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			if frontmatterDelimiter.MatchString(lines[i]) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return nil, []lintProblem{{source: path, line: 1, msg: "missing frontmatter; start the file with a --- block holding at least a title"}}
+	}
+
+	var meta map[string]interface{}
+	if err := yaml.Unmarshal([]byte(strings.Join(lines[1:end], "\n")), &meta); err != nil {
+		return nil, []lintProblem{{source: path, line: 1, msg: fmt.Sprintf("frontmatter is not valid YAML: %v", err)}}
+	}
+
+	keyLine := func(key string) int {
+		pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(key) + `\s*:`)
+		for i := 1; i < end; i++ {
+			if pattern.MatchString(lines[i]) {
+				return i + 1
+			}
+		}
+		return 0
+	}
+
+	var problems []lintProblem
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		check, ok := frontmatterKeys[key]
+		if !ok {
+			problems = append(problems, lintProblem{source: path, line: keyLine(key), msg: fmt.Sprintf("unknown key %q", key)})
+			continue
+		}
+		if err := check(meta[key]); err != nil {
+			problems = append(problems, lintProblem{source: path, line: keyLine(key), msg: fmt.Sprintf("%s %v", key, err)})
+		}
+	}
+
+	if title, ok := meta["title"]; !ok {
+		problems = append(problems, lintProblem{source: path, line: 1, msg: "missing title"})
+	} else if s, isString := title.(string); isString && strings.TrimSpace(s) == "" {
+		problems = append(problems, lintProblem{source: path, line: keyLine("title"), msg: "title is empty"})
+	}
+	if _, ok := meta["date"]; !ok && section == "posts" {
+		problems = append(problems, lintProblem{source: path, line: 1, msg: "missing date"})
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].line < problems[j].line })
+	return meta, problems
+}
+
+func wantString(v interface{}) error {
+	if _, ok := v.(string); !ok {
+		return fmt.Errorf("must be text, got %s", describeValue(v))
+	}
+	return nil
+}
+
+func wantBool(v interface{}) error {
+	if _, ok := v.(bool); !ok {
+		return fmt.Errorf("must be true or false, got %s", describeValue(v))
+	}
+	return nil
+}
+
+func wantInt(v interface{}) error {
+	if _, ok := v.(int); !ok {
+		return fmt.Errorf("must be a whole number, got %s", describeValue(v))
+	}
+	return nil
+}
+
+func wantDate(v interface{}) error {
+	switch v.(type) {
+	case string, time.Time:
+		_, err := parseDate(v)
+		return err
+	}
+	return fmt.Errorf("must be a date (YYYY-MM-DD or RFC 3339), got %s", describeValue(v))
+}
+
+func wantStringList(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		return nil
+	case []interface{}:
+		for _, item := range v {
+			switch item.(type) {
+			case []interface{}, map[interface{}]interface{}, nil:
+				return fmt.Errorf("must be a list of names, but contains %s", describeValue(item))
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("must be a list of names or a single name, got %s", describeValue(v))
+}
+
+// describeValue names the YAML type of v for error messages, quoting
+// strings so that `draft: "true"` reads as the mistake it is.
+func describeValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "an empty value"
+	case string:
+		return fmt.Sprintf("the text %q", v)
+	case bool:
+		return fmt.Sprintf("the boolean %v", v)
+	case int, int64, uint64, float64:
+		return fmt.Sprintf("the number %v", v)
+	case time.Time:
+		return "a date"
+	case []interface{}:
+		return "a list"
+	case map[interface{}]interface{}:
+		return "a mapping"
+	}
+	return fmt.Sprintf("a value of type %T", v)
+}
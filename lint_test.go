@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintContentHomePageCollisions(t *testing.T) {
+	tests := []struct {
+		name string
+		site SiteConfig
+		file string
+		body string
+		want string // expected problem; empty for none
+	}{
+		{
+			name: "top-level index.md",
+			file: "posts/index.md",
+			body: "title: Index\ndate: 2026-01-01\n",
+		},
+		{
+			name: "top-level index.md with ugly URLs",
+			site: SiteConfig{UglyURLs: true, Permalinks: map[string]string{"pages": "/:slug/"}},
+			file: "pages/index.md",
+			body: "title: Index\n",
+			want: "publishes to /index.html, which the generator writes itself",
+		},
+		{
+			name: "dot slug",
+			file: "pages/about.md",
+			body: "title: About\nslug: .\n",
+			want: `"." is not a usable slug`,
+		},
+		{
+			name: "slug resolving to the home page",
+			file: "pages/about.md",
+			body: "title: About\nslug: ./\n",
+			want: "publishes to /.//, which the generator writes itself",
+		},
+		{
+			name: "post pattern at the site root",
+			site: SiteConfig{Permalinks: map[string]string{"posts": "/:slug.html"}},
+			file: "posts/2026-01-01-index.md",
+			body: "title: Index\ndate: 2026-01-01\n",
+			want: "publishes to /index.html, which the generator writes itself",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			site := tt.site
+			site.ContentDir = filepath.Join(dir, "posts")
+			site.PagesDir = filepath.Join(dir, "pages")
+			for _, d := range []string{site.ContentDir, site.PagesDir} {
+				if err := os.MkdirAll(d, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			source := "---\n" + tt.body + "---\nBody.\n"
+			if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(source), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			problems, err := lintContent(site)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msgs []string
+			for _, p := range problems {
+				msgs = append(msgs, p.msg)
+			}
+			switch {
+			case tt.want == "" && len(problems) > 0:
+				t.Errorf("unexpected problems: %q", msgs)
+			case tt.want != "" && (len(problems) != 1 || !strings.Contains(problems[0].msg, tt.want)):
+				t.Errorf("problems = %q, want one containing %q", msgs, tt.want)
+			}
+		})
+	}
+}
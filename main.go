@@ -8,49 +8,121 @@ import (
 	"html/template"
 	"io"
 	"io/fs"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/yuin/goldmark"
 	meta "github.com/yuin/goldmark-meta"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
 	"gopkg.in/yaml.v2"
 )
 
+// Defaults for settings site.yml leaves out.
 const (
-	contentDir   = "posts"
-	templateDir  = "templates"
-	staticDir    = "static"
-	outputDir    = "docs"
-	postsPerPage = 5
-	postsInFeed  = 20
+	defaultContentDir   = "posts"
+	defaultPagesDir     = "pages"
+	defaultTemplateDir  = "templates"
+	defaultStaticDir    = "static"
+	defaultOutputDir    = "docs"
+	defaultPostsPerPage = 5
+	defaultPostsInFeed  = 20
 )
 
 type SiteConfig struct {
-	Title       string `yaml:"title"`
-	URL         string `yaml:"url"`
-	Description string `yaml:"description"`
+	Title           string `yaml:"title"`
+	URL             string `yaml:"url"`
+	Description     string `yaml:"description"`
+	Author          string `yaml:"author"`
+	PostsPerPage    int    `yaml:"posts_per_page"`
+	PostsInFeed     int    `yaml:"posts_in_feed"`
+	FeedFullContent bool   `yaml:"feed_full_content"`
+
+	// Where the site lives. Relative directories are resolved against the
+	// source directory, and loadConfig replaces them with the resolved
+	// paths. They do not affect how content renders, so they stay out of
+	// the build cache key.
+	ContentDir  string `yaml:"content_dir" json:"-"`
+	PagesDir    string `yaml:"pages_dir" json:"-"`
+	TemplateDir string `yaml:"template_dir" json:"-"`
+	StaticDir   string `yaml:"static_dir" json:"-"`
+	OutputDir   string `yaml:"output_dir" json:"-"`
+	SourceDir   string `yaml:"-" json:"-"`
+	CacheDir    string `yaml:"-" json:"-"`
+
+	Markdown  MarkdownConfig  `yaml:"markdown"`
+	Highlight HighlightConfig `yaml:"highlight"`
+	Sanitize  SanitizeConfig  `yaml:"sanitize"`
+	Images    ImageConfig     `yaml:"images"`
+
+	// Permalinks maps a section, posts or pages, to its URL pattern.
+	Permalinks map[string]string `yaml:"permalinks"`
+	UglyURLs   bool              `yaml:"ugly_urls"`
+
+	// Menu holds the standalone pages marked `menu: true`, in nav order.
+	// It is filled in during the build rather than read from site.yml.
+	Menu []*Post `yaml:"-" json:"-"`
 }
 
+// Post is a piece of Markdown content: a dated post from posts/ or, with
+// Section set to "pages", a standalone page from pages/. Bundle is the
+// directory of a post written as <dir>/index.md, whose other files are
+// published alongside it.
 type Post struct {
 	Title       string
 	Slug        string
+	Section     string
+	Source      string
+	Bundle      string
 	Date        time.Time
+	Expires     time.Time
 	Description string
+	Tags        []string
+	Categories  []string
 	Content     template.HTML
 	URL         string
+	Aliases     []string
+	Draft       bool
+	Sitemap     bool
+	Menu        bool
+	Weight      int
+
+	// RawHTMLOmitted records that the post contains raw HTML which was
+	// dropped because the site default renders without `unsafe`. Posts that
+	// set `unsafe: false` themselves are not flagged.
+	RawHTMLOmitted bool
+
+	// Sanitized lists what the sanitizer removed from the rendered content.
+	Sanitized []string
+
+	// TableOfContents is a nested list linking to the post's headings, or
+	// empty when the post has fewer than two or sets `toc: false`.
+	TableOfContents template.HTML
+
+	// cached records that this build took the post from the build cache.
+	cached bool
 }
 
 type HomePage struct {
-	Site  SiteConfig
-	Posts []*Post
+	Site      SiteConfig
+	Posts     []*Post
+	Paginator *Paginator
+}
+
+// Paginator describes one page of the home page listing. PrevURL points at
+// newer posts and NextURL at older ones; either is empty at the ends.
+type Paginator struct {
+	Page       int
+	TotalPages int
+	PrevURL    string
+	NextURL    string
 }
 
 type PostPage struct {
@@ -58,6 +130,11 @@ type PostPage struct {
 	Post *Post
 }
 
+type StaticPage struct {
+	Site SiteConfig
+	Page *Post
+}
+
 type ArchivePage struct {
 	Site  SiteConfig
 	Years []YearGroup
@@ -69,9 +146,10 @@ type YearGroup struct {
 }
 
 type RSSFeed struct {
-	XMLName xml.Name   `xml:"rss"`
-	Version string     `xml:"version,attr"`
-	Channel RSSChannel `xml:"channel"`
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	ContentNS string     `xml:"xmlns:content,attr,omitempty"`
+	Channel   RSSChannel `xml:"channel"`
 }
 
 type RSSChannel struct {
@@ -83,11 +161,16 @@ type RSSChannel struct {
 }
 
 type RSSItem struct {
-	Title       string `xml:"title"`
-	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	PubDate     string `xml:"pubDate"`
-	GUID        string `xml:"guid"`
+	Title       string      `xml:"title"`
+	Link        string      `xml:"link"`
+	Description string      `xml:"description"`
+	Content     *RSSContent `xml:"content:encoded,omitempty"`
+	PubDate     string      `xml:"pubDate"`
+	GUID        string      `xml:"guid"`
+}
+
+type RSSContent struct {
+	HTML string `xml:",cdata"`
 }
 
 func version() string {
@@ -106,18 +189,24 @@ func main() {
 	var err error
 	switch cmd {
 	case "generate":
-		err = runGenerate()
+		err = runGenerate(os.Args[2:])
 	case "serve":
-		err = runServe()
+		err = runServe(os.Args[2:])
 	case "clean":
-		err = runClean()
+		err = runClean(os.Args[2:])
 	case "new":
 		err = runNew(os.Args[2:])
 	case "init":
 		err = runInit(os.Args[2:])
+	case "gen-css":
+		err = runGenCSS(os.Args[2:])
+	case "check":
+		err = runCheck(os.Args[2:])
+	case "lint":
+		err = runLint(os.Args[2:])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
-		fmt.Fprintf(os.Stderr, "Usage: blog {generate|serve|clean|new|init}\n")
+		fmt.Fprintf(os.Stderr, "Usage: blog {generate|serve|clean|new|init|gen-css|check|lint}\n")
 		os.Exit(1)
 	}
 
@@ -127,21 +216,23 @@ func main() {
 	}
 }
 
-func runServe() error {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	fmt.Printf("Serving %s on http://0.0.0.0:%s\n", outputDir, port)
-	return http.ListenAndServe(":"+port, http.FileServer(http.Dir(outputDir)))
-}
+func runClean(args []string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	var opts siteOptions
+	siteFlags(fs, &opts)
+	fs.Parse(args)
 
-func runClean() error {
-	if err := cleanDir(outputDir); err != nil {
+	site, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	if err := cleanDir(site.OutputDir); err != nil {
 		return fmt.Errorf("cleaning output dir: %w", err)
 	}
-	fmt.Println("Cleaned output directory")
+	if err := os.RemoveAll(site.CacheDir); err != nil {
+		return fmt.Errorf("removing build cache: %w", err)
+	}
+	fmt.Println("Cleaned output directory and build cache")
 	return nil
 }
 
@@ -152,26 +243,43 @@ func runNew(args []string) error {
 	description := fs.String("description", "", "Post description")
 	url := fs.String("url", "", "Fetch URL and convert HTML via pandoc")
 	stdin := fs.Bool("stdin", false, "Read HTML from stdin and convert via pandoc")
+	sanitize := fs.Bool("sanitize", false, "Sanitize fetched HTML before converting it")
+	var opts siteOptions
+	siteFlags(fs, &opts)
 	fs.Parse(args)
 
 	if *title == "" {
 		return fmt.Errorf("--title is required")
 	}
 
+	// The configuration is optional here: without it, posts go to the
+	// default content directory.
+	site := SiteConfig{ContentDir: filepath.Join(opts.Source, defaultContentDir)}
+	if _, err := os.Stat(opts.configPath()); err == nil {
+		if site, err = loadConfig(opts); err != nil {
+			return err
+		}
+	}
+
 	slug := slugify(*title)
 	filename := fmt.Sprintf("%s-%s.md", *date, slug)
-	filepath := filepath.Join(contentDir, filename)
+	filepath := filepath.Join(site.ContentDir, filename)
+
+	var policy *sanitizePolicy
+	if *sanitize {
+		policy = buildSanitizePolicy(site.Sanitize)
+	}
 
 	var body string
 	var err error
 
 	if *url != "" {
-		body, err = convertURL(*url)
+		body, err = convertURL(*url, policy)
 		if err != nil {
 			return fmt.Errorf("converting URL: %w", err)
 		}
 	} else if *stdin {
-		body, err = convertStdin()
+		body, err = convertStdin(policy)
 		if err != nil {
 			return fmt.Errorf("converting stdin: %w", err)
 		}
@@ -191,7 +299,7 @@ func runNew(args []string) error {
 		buf.WriteString("\n")
 	}
 
-	if err := os.MkdirAll(contentDir, 0o755); err != nil {
+	if err := os.MkdirAll(site.ContentDir, 0o755); err != nil {
 		return fmt.Errorf("creating content dir: %w", err)
 	}
 
@@ -229,6 +337,7 @@ func runInit(args []string) error {
 	// Create directory structure
 	dirs := []string{
 		filepath.Join(target, "posts"),
+		filepath.Join(target, "pages"),
 		filepath.Join(target, "docs"),
 		filepath.Join(target, "templates"),
 		filepath.Join(target, "static", "css"),
@@ -243,6 +352,10 @@ func runInit(args []string) error {
 	siteYml := `title: "My Blog"
 url: "https://example.com"
 description: "A blog about things"
+author: "Your Name"
+posts_per_page: 5
+highlight:
+  style: github
 `
 	if err := os.WriteFile(filepath.Join(target, "site.yml"), []byte(siteYml), 0o644); err != nil {
 		return err
@@ -251,6 +364,7 @@ description: "A blog about things"
 	// .gitkeep files
 	for _, path := range []string{
 		filepath.Join(target, "posts", ".gitkeep"),
+		filepath.Join(target, "pages", ".gitkeep"),
 		filepath.Join(target, "docs", ".gitkeep"),
 	} {
 		if err := os.WriteFile(path, []byte{}, 0o644); err != nil {
@@ -261,6 +375,7 @@ description: "A blog about things"
 	// .gitignore
 	gitignore := `.env
 .DS_Store
+.blogcache/
 `
 	if err := os.WriteFile(filepath.Join(target, ".gitignore"), []byte(gitignore), 0o644); err != nil {
 		return err
@@ -275,7 +390,10 @@ description: "A blog about things"
     <title>{{block "title" .}}{{.Site.Title}}{{end}}</title>
     <meta name="generator" content="{{generator}}">
     <link rel="stylesheet" href="/css/style.css">
+    <link rel="stylesheet" href="/css/syntax.css">
     <link rel="alternate" type="application/rss+xml" title="RSS Feed" href="/feed.xml">
+    <link rel="alternate" type="application/atom+xml" title="Atom Feed" href="/atom.xml">
+    <link rel="alternate" type="application/feed+json" title="JSON Feed" href="/feed.json">
 </head>
 <body>
     <header>
@@ -284,7 +402,9 @@ description: "A blog about things"
             <div class="nav-links">
                 <a href="/">Home</a>
                 <a href="/archive/">Archive</a>
-                <a href="/feed.xml">RSS</a>
+                <a href="/tags/">Tags</a>
+                {{range .Site.Menu}}<a href="{{.URL}}">{{.Title}}</a>
+                {{end}}<a href="/feed.xml">RSS</a>
             </div>
         </nav>
     </header>
@@ -302,7 +422,7 @@ description: "A blog about things"
 	}
 
 	// templates/home.html
-	homeHTML := `{{define "title"}}{{.Site.Title}}{{end}}
+	homeHTML := `{{define "title"}}{{if gt .Paginator.Page 1}}Page {{.Paginator.Page}} — {{end}}{{.Site.Title}}{{end}}
 {{define "content"}}
 <h1>Recent Posts</h1>
 {{range .Posts}}
@@ -314,6 +434,13 @@ description: "A blog about things"
 {{else}}
 <p>No posts yet.</p>
 {{end}}
+{{if gt .Paginator.TotalPages 1}}
+<nav class="pagination">
+    {{if .Paginator.PrevURL}}<a href="{{.Paginator.PrevURL}}" rel="prev">&larr; Newer</a>{{end}}
+    <span>Page {{.Paginator.Page}} of {{.Paginator.TotalPages}}</span>
+    {{if .Paginator.NextURL}}<a href="{{.Paginator.NextURL}}" rel="next">Older &rarr;</a>{{end}}
+</nav>
+{{end}}
 {{end}}
 `
 	if err := os.WriteFile(filepath.Join(target, "templates", "home.html"), []byte(homeHTML), 0o644); err != nil {
@@ -324,13 +451,25 @@ description: "A blog about things"
 	postHTML := `{{define "title"}}{{.Post.Title}} — {{.Site.Title}}{{end}}
 {{define "content"}}
 <article class="post">
+    {{if .Post.Draft}}<p class="draft-banner">Draft — not published</p>{{end}}
     <header class="post-header">
         <h1>{{.Post.Title}}</h1>
         <time datetime="{{.Post.Date.Format "2006-01-02"}}">{{formatDate .Post.Date}}</time>
     </header>
+    {{if .Post.TableOfContents}}
+    <nav class="toc">
+        <h2>Contents</h2>
+        {{.Post.TableOfContents}}
+    </nav>
+    {{end}}
     <div class="post-content">
         {{.Post.Content}}
     </div>
+    {{if .Post.Tags}}
+    <footer class="post-tags">
+        {{range .Post.Tags}}<a href="{{termURL "tags" .}}">{{.}}</a> {{end}}
+    </footer>
+    {{end}}
 </article>
 {{end}}
 `
@@ -361,6 +500,57 @@ description: "A blog about things"
 		return err
 	}
 
+	// templates/page.html
+	pageHTML := `{{define "title"}}{{.Page.Title}} — {{.Site.Title}}{{end}}
+{{define "content"}}
+<article class="page">
+    {{if .Page.Draft}}<p class="draft-banner">Draft — not published</p>{{end}}
+    <h1>{{.Page.Title}}</h1>
+    <div class="post-content">
+        {{.Page.Content}}
+    </div>
+</article>
+{{end}}
+`
+	if err := os.WriteFile(filepath.Join(target, "templates", "page.html"), []byte(pageHTML), 0o644); err != nil {
+		return err
+	}
+
+	// templates/taxonomy.html
+	taxonomyHTML := `{{define "title"}}{{.Taxonomy.Title}} — {{.Site.Title}}{{end}}
+{{define "content"}}
+<h1>{{.Taxonomy.Title}}</h1>
+<ul class="term-list">
+    {{range .Taxonomy.Terms}}
+    <li><a href="{{.URL}}">{{.Name}}</a> <span class="term-count">{{len .Posts}}</span></li>
+    {{else}}
+    <li>Nothing here yet.</li>
+    {{end}}
+</ul>
+{{end}}
+`
+	if err := os.WriteFile(filepath.Join(target, "templates", "taxonomy.html"), []byte(taxonomyHTML), 0o644); err != nil {
+		return err
+	}
+
+	// templates/term.html
+	termHTML := `{{define "title"}}{{.Term.Name}} — {{.Site.Title}}{{end}}
+{{define "content"}}
+<h1>{{.Term.Name}}</h1>
+<p class="term-meta"><a href="{{.Taxonomy.URL}}">All {{.Taxonomy.Name}}</a> · <a href="{{.Term.FeedURL}}">RSS</a></p>
+{{range .Term.Posts}}
+<article class="post-summary">
+    <h2><a href="{{.URL}}">{{.Title}}</a></h2>
+    <time datetime="{{.Date.Format "2006-01-02"}}">{{formatDate .Date}}</time>
+    {{if .Description}}<p>{{.Description}}</p>{{end}}
+</article>
+{{end}}
+{{end}}
+`
+	if err := os.WriteFile(filepath.Join(target, "templates", "term.html"), []byte(termHTML), 0o644); err != nil {
+		return err
+	}
+
 	// static/css/style.css
 	styleCSS := `*,
 *::before,
@@ -419,9 +609,37 @@ a:hover { color: #004499; }
 .post-summary time { color: #888; font-size: 0.9rem; }
 .post-summary p { margin-top: 0.5rem; color: #555; }
 
+.pagination {
+    display: flex;
+    justify-content: space-between;
+    margin-top: 2rem;
+    color: #888;
+    font-size: 0.9rem;
+}
+
+.draft-banner {
+    margin-bottom: 1.5rem;
+    padding: 0.5rem 1rem;
+    background: #fff4d6;
+    border: 1px solid #f0d68a;
+    border-radius: 4px;
+    font-size: 0.9rem;
+}
+
 .post-header { margin-bottom: 2rem; }
 .post-header time { color: #888; font-size: 0.9rem; }
 
+.toc {
+    margin-bottom: 2rem;
+    padding: 1rem 1.5rem;
+    background: #fafafa;
+    border-left: 3px solid #eee;
+    font-size: 0.9rem;
+}
+.toc h2 { font-size: 1rem; }
+.toc ul { padding-left: 1.25rem; }
+.toc li { margin-top: 0.25rem; }
+
 .post-content h2 { margin-top: 2rem; }
 .post-content h3 { margin-top: 1.5rem; font-size: 1.2rem; }
 .post-content p { margin-top: 1rem; }
@@ -444,12 +662,35 @@ a:hover { color: #004499; }
     font-size: 0.9em;
 }
 
+.post-content table {
+    margin-top: 1rem;
+    border-collapse: collapse;
+}
+
+.post-content th,
+.post-content td {
+    padding: 0.25rem 0.75rem;
+    border: 1px solid #ddd;
+}
+
+.post-content dt { margin-top: 1rem; font-weight: 600; }
+.post-content dd { margin-left: 1.5rem; }
+.post-content .footnotes { margin-top: 2rem; font-size: 0.9rem; }
+
 .post-content :not(pre) > code {
     background: #f5f5f5;
     padding: 0.15em 0.3em;
     border-radius: 3px;
 }
 
+.post-tags { margin-top: 2rem; font-size: 0.9rem; }
+.post-tags a { margin-right: 0.5rem; }
+
+.term-meta { margin-bottom: 2rem; color: #888; font-size: 0.9rem; }
+.term-list { list-style: none; padding: 0; }
+.term-list li { margin-top: 0.5rem; }
+.term-count { color: #888; font-size: 0.9rem; }
+
 .archive-year { margin-bottom: 2rem; }
 .archive-year ul { list-style: none; padding: 0; }
 .archive-year li { margin-top: 0.5rem; }
@@ -472,6 +713,15 @@ footer {
 		return err
 	}
 
+	// static/css/syntax.css
+	var syntaxCSS bytes.Buffer
+	if err := writeHighlightCSS(&syntaxCSS, defaultHighlightStyle); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(target, "static", "css", "syntax.css"), syntaxCSS.Bytes(), 0o644); err != nil {
+		return err
+	}
+
 	fmt.Printf("Created blog at %s\n", target)
 	fmt.Println()
 	fmt.Println("Next steps:")
@@ -494,40 +744,37 @@ func slugify(s string) string {
 	return s
 }
 
-func convertURL(url string) (string, error) {
+func convertURL(url string, policy *sanitizePolicy) (string, error) {
 	curl := exec.Command("curl", "-sL", url)
-	pandoc := exec.Command("pandoc", "-f", "html", "-t", "markdown-fenced_divs-bracketed_spans-header_attributes-link_attributes", "--wrap=none")
-
-	pipe, err := curl.StdoutPipe()
+	curl.Stderr = os.Stderr
+	page, err := curl.Output()
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("curl failed: %w", err)
 	}
-	pandoc.Stdin = pipe
-
-	var out bytes.Buffer
-	pandoc.Stdout = &out
-	pandoc.Stderr = os.Stderr
+	return convertHTML(page, policy)
+}
 
-	if err := curl.Start(); err != nil {
-		return "", fmt.Errorf("starting curl: %w", err)
-	}
-	if err := pandoc.Start(); err != nil {
-		return "", fmt.Errorf("starting pandoc: %w", err)
+func convertStdin(policy *sanitizePolicy) (string, error) {
+	page, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("reading stdin: %w", err)
 	}
+	return convertHTML(page, policy)
+}
 
-	if err := curl.Wait(); err != nil {
-		return "", fmt.Errorf("curl failed: %w", err)
-	}
-	if err := pandoc.Wait(); err != nil {
-		return "", fmt.Errorf("pandoc failed: %w", err)
+// convertHTML turns page into Markdown with pandoc, first sanitizing it
+// with policy unless that is nil.
+func convertHTML(page []byte, policy *sanitizePolicy) (string, error) {
+	if policy != nil {
+		clean, removed := policy.Sanitize(string(page))
+		for _, what := range removed {
+			fmt.Fprintf(os.Stderr, "Sanitized: removed %s\n", what)
+		}
+		page = []byte(clean)
 	}
 
-	return out.String(), nil
-}
-
-func convertStdin() (string, error) {
 	cmd := exec.Command("pandoc", "-f", "html", "-t", "markdown-fenced_divs-bracketed_spans-header_attributes-link_attributes", "--wrap=none")
-	cmd.Stdin = os.Stdin
+	cmd.Stdin = bytes.NewReader(page)
 	cmd.Stderr = os.Stderr
 
 	out, err := cmd.Output()
@@ -537,15 +784,97 @@ func convertStdin() (string, error) {
 	return string(out), nil
 }
 
-func loadConfig() (SiteConfig, error) {
-	data, err := os.ReadFile("site.yml")
+// siteOptions locate a site: the directory holding it, its configuration
+// file and where to write the generated output.
+type siteOptions struct {
+	Source      string
+	Config      string
+	Destination string
+}
+
+// siteFlags registers the flags that locate the site on fs.
+func siteFlags(fs *flag.FlagSet, opts *siteOptions) {
+	fs.StringVar(&opts.Source, "source", ".", "Site directory; relative paths in the config are resolved against it")
+	fs.StringVar(&opts.Config, "config", "", "Configuration file (default: site.yml in the source directory)")
+	fs.StringVar(&opts.Destination, "destination", "", "Output directory (default: output_dir from the config)")
+}
+
+func (opts siteOptions) configPath() string {
+	if opts.Config != "" {
+		return opts.Config
+	}
+	return filepath.Join(opts.Source, "site.yml")
+}
+
+func loadConfig(opts siteOptions) (SiteConfig, error) {
+	if opts.Source == "" {
+		opts.Source = "."
+	}
+	path := opts.configPath()
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return SiteConfig{}, fmt.Errorf("reading site.yml: %w", err)
+		return SiteConfig{}, fmt.Errorf("reading %s: %w", path, err)
 	}
 
 	var cfg SiteConfig
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return SiteConfig{}, fmt.Errorf("parsing site.yml: %w", err)
+		return SiteConfig{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	dirs := []struct {
+		dir *string
+		def string
+	}{
+		{&cfg.ContentDir, defaultContentDir},
+		{&cfg.PagesDir, defaultPagesDir},
+		{&cfg.TemplateDir, defaultTemplateDir},
+		{&cfg.StaticDir, defaultStaticDir},
+		{&cfg.OutputDir, defaultOutputDir},
+	}
+	for _, d := range dirs {
+		if *d.dir == "" {
+			*d.dir = d.def
+		}
+		if !filepath.IsAbs(*d.dir) {
+			*d.dir = filepath.Join(opts.Source, *d.dir)
+		}
+	}
+	if opts.Destination != "" {
+		cfg.OutputDir = opts.Destination
+	}
+	cfg.SourceDir = opts.Source
+	cfg.CacheDir = filepath.Join(opts.Source, cacheDirName)
+
+	// Every build deletes what it did not write to the output directory, so
+	// it must not hold the site itself. Nor may it sit among the inputs,
+	// where the next build would read its own output back in.
+	for _, d := range []struct {
+		name, dir string
+		input     bool
+	}{
+		{"source directory", opts.Source, false},
+		{"content_dir", cfg.ContentDir, true},
+		{"pages_dir", cfg.PagesDir, true},
+		{"template_dir", cfg.TemplateDir, true},
+		{"static_dir", cfg.StaticDir, true},
+	} {
+		holds, err := containsPath(cfg.OutputDir, d.dir)
+		if err != nil {
+			return SiteConfig{}, err
+		}
+		if holds {
+			return SiteConfig{}, fmt.Errorf("output directory %s contains the %s %s; building there would delete it", cfg.OutputDir, d.name, d.dir)
+		}
+		if !d.input {
+			continue
+		}
+		inside, err := containsPath(d.dir, cfg.OutputDir)
+		if err != nil {
+			return SiteConfig{}, err
+		}
+		if inside {
+			return SiteConfig{}, fmt.Errorf("output directory %s is inside the %s %s; each build would read the last one's output", cfg.OutputDir, d.name, d.dir)
+		}
 	}
 
 	if cfg.Title == "" {
@@ -554,65 +883,315 @@ func loadConfig() (SiteConfig, error) {
 	if cfg.URL == "" {
 		cfg.URL = "https://example.com"
 	}
+	if cfg.PostsPerPage <= 0 {
+		cfg.PostsPerPage = defaultPostsPerPage
+	}
+	if cfg.PostsInFeed <= 0 {
+		cfg.PostsInFeed = defaultPostsInFeed
+	}
+	if cfg.Highlight.Style == "" {
+		cfg.Highlight.Style = defaultHighlightStyle
+	}
+	if _, err := highlightStyle(cfg.Highlight.Style); err != nil {
+		return SiteConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validatePermalinks(cfg.Permalinks); err != nil {
+		return SiteConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, w := range cfg.Images.Widths {
+		if w <= 0 {
+			return SiteConfig{}, fmt.Errorf("%s: images.widths must be positive, got %d", path, w)
+		}
+	}
+	if cfg.Images.Quality <= 0 || cfg.Images.Quality > 100 {
+		cfg.Images.Quality = defaultImageQuality
+	}
+	if cfg.Images.Sizes == "" {
+		cfg.Images.Sizes = defaultImageSizes
+	}
 
 	return cfg, nil
 }
 
-func runGenerate() error {
-	site, err := loadConfig()
+// containsPath reports whether p is dir or lies inside it.
+func containsPath(dir, p string) (bool, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	absP, err := filepath.Abs(p)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absDir, absP)
+	if err != nil {
+		return false, nil
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))), nil
+}
+
+// buildOptions holds the command-line switches that affect a build.
+type buildOptions struct {
+	siteOptions
+	Force      bool
+	Jobs       int
+	Drafts     bool
+	FeedDrafts bool
+	Future     bool
+	Strict     bool
+}
+
+// buildFlags registers the build flags shared by generate and serve. The
+// returned options are filled in when fs is parsed.
+func buildFlags(fs *flag.FlagSet) *buildOptions {
+	opts := &buildOptions{}
+	siteFlags(fs, &opts.siteOptions)
+	fs.IntVar(&opts.Jobs, "jobs", runtime.GOMAXPROCS(0), "Number of posts to convert and render in parallel")
+	fs.BoolVar(&opts.Drafts, "drafts", false, "Include drafts in the build")
+	fs.BoolVar(&opts.FeedDrafts, "feed-drafts", false, "With --drafts, also list drafts in feeds and the sitemap")
+	fs.BoolVar(&opts.Future, "future", false, "Include posts dated in the future")
+	fs.BoolVar(&opts.Strict, "strict", false, "Fail the build on any problem blog lint reports")
+	return opts
+}
+
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	opts := buildFlags(fs)
+	fs.BoolVar(&opts.Force, "force", false, "Ignore the build cache and rewrite every output file")
+	fs.Parse(args)
+
+	return build(*opts)
+}
+
+func build(opts buildOptions) error {
+	site, err := loadConfig(opts.siteOptions)
 	if err != nil {
 		return err
 	}
 
-	if err := cleanDir(outputDir); err != nil {
-		return fmt.Errorf("cleaning output dir: %w", err)
+	if opts.Strict {
+		problems, err := lintContent(site)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return lintError(problems)
+		}
+	}
+
+	if opts.Force {
+		if err := cleanDir(site.OutputDir); err != nil {
+			return fmt.Errorf("cleaning output dir: %w", err)
+		}
 	}
+	out := newOutputWriter(site.OutputDir)
 
-	tmpl, err := parseTemplates()
+	tmpl, err := parseTemplates(site.TemplateDir)
 	if err != nil {
 		return fmt.Errorf("parsing templates: %w", err)
 	}
 
-	posts, err := parsePosts()
+	if opts.Drafts {
+		fmt.Println("Including drafts: do not deploy this build")
+	}
+
+	md := newMarkdown(site)
+	cache := loadPostCache(site, opts.Force)
+
+	posts, err := parsePosts(md, cache, site, opts)
 	if err != nil {
 		return fmt.Errorf("parsing posts: %w", err)
 	}
 
-	sort.Slice(posts, func(i, j int) bool {
+	pages, err := parsePages(md, cache, site, opts)
+	if err != nil {
+		return fmt.Errorf("parsing pages: %w", err)
+	}
+
+	if err := cache.Save(); err != nil {
+		return fmt.Errorf("saving build cache: %w", err)
+	}
+
+	reportOmittedHTML(append(posts[:len(posts):len(posts)], pages...))
+	reportSanitized(append(posts[:len(posts):len(posts)], pages...))
+
+	now := time.Now()
+	posts, scheduledPosts := applySchedule(posts, now, opts.Future)
+	pages, scheduledPages := applySchedule(pages, now, opts.Future)
+	scheduled := append(scheduledPosts, scheduledPages...)
+	site.Menu = menuPages(pages)
+
+	// Stable so posts sharing a date keep filename order from build to build.
+	sort.SliceStable(posts, func(i, j int) bool {
 		return posts[i].Date.After(posts[j].Date)
 	})
 
-	fmt.Printf("Found %d posts\n", len(posts))
+	fmt.Printf("Found %d posts (%d unchanged since last build)\n", len(posts), countCached(posts))
+	if len(pages) > 0 {
+		fmt.Printf("Found %d pages (%d unchanged since last build)\n", len(pages), countCached(pages))
+	}
 
-	if err := generatePostPages(tmpl, site, posts); err != nil {
+	if err := resolvePostLinks(site, append(posts[:len(posts):len(posts)], pages...)); err != nil {
+		return err
+	}
+
+	assets, err := staticAssets(site.StaticDir)
+	if err != nil {
+		return fmt.Errorf("listing static files: %w", err)
+	}
+	bundled, err := bundleAssets(append(posts[:len(posts):len(posts)], pages...))
+	if err != nil {
+		return fmt.Errorf("listing bundle files: %w", err)
+	}
+	for u, file := range bundled {
+		assets[u] = file
+	}
+	if err := processImages(out, site, append(posts[:len(posts):len(posts)], pages...), assets, opts.Jobs, opts.Force); err != nil {
+		return fmt.Errorf("processing images: %w", err)
+	}
+
+	// Drafts only reach feeds and the sitemap when asked for explicitly.
+	published, publishedPages := posts, pages
+	if !opts.FeedDrafts {
+		published, publishedPages = withoutDrafts(posts), withoutDrafts(pages)
+	}
+
+	if err := generatePostPages(out, tmpl, site, posts, opts.Jobs); err != nil {
 		return fmt.Errorf("generating post pages: %w", err)
 	}
 
-	if err := generateHomePage(tmpl, site, posts); err != nil {
+	if err := generateStaticPages(out, tmpl, site, pages); err != nil {
+		return fmt.Errorf("generating pages: %w", err)
+	}
+
+	if err := generateHomePage(out, tmpl, site, posts); err != nil {
 		return fmt.Errorf("generating home page: %w", err)
 	}
 
-	if err := generateArchivePage(tmpl, site, posts); err != nil {
+	if err := generateArchivePage(out, tmpl, site, posts); err != nil {
 		return fmt.Errorf("generating archive page: %w", err)
 	}
 
-	if err := generateRSSFeed(site, posts); err != nil {
+	if err := generateRSSFeed(out, site, published); err != nil {
 		return fmt.Errorf("generating RSS feed: %w", err)
 	}
 
-	if err := copyStaticFiles(); err != nil {
+	if err := generateAtomFeed(out, site, published); err != nil {
+		return fmt.Errorf("generating Atom feed: %w", err)
+	}
+
+	if err := generateJSONFeed(out, site, published); err != nil {
+		return fmt.Errorf("generating JSON feed: %w", err)
+	}
+
+	if err := generateTaxonomies(out, tmpl, site, posts, opts.FeedDrafts); err != nil {
+		return fmt.Errorf("generating taxonomies: %w", err)
+	}
+
+	if err := generateSitemap(out, site, published, publishedPages); err != nil {
+		return fmt.Errorf("generating sitemap: %w", err)
+	}
+
+	if err := copyStaticFiles(out, site.StaticDir); err != nil {
 		return fmt.Errorf("copying static files: %w", err)
 	}
 
-	if err := os.WriteFile(filepath.Join(outputDir, ".nojekyll"), []byte{}, 0o644); err != nil {
-		return fmt.Errorf("writing .nojekyll: %w", err)
+	if err := copyBundleAssets(out, bundled); err != nil {
+		return fmt.Errorf("copying bundle files: %w", err)
+	}
+
+	if err := generateAliases(out, site, append(posts[:len(posts):len(posts)], pages...)); err != nil {
+		return err
+	}
+
+	if !out.Has(".nojekyll") {
+		if err := out.WriteFile(".nojekyll", []byte{}); err != nil {
+			return fmt.Errorf("writing .nojekyll: %w", err)
+		}
+	}
+
+	removed, err := out.Prune()
+	if err != nil {
+		return fmt.Errorf("removing stale output: %w", err)
 	}
 
+	fmt.Printf("Wrote %d files (%d unchanged, %d removed)\n", out.Written(), out.Unchanged(), removed)
 	fmt.Println("Site generated successfully!")
+
+	reportScheduled(scheduled, opts.Future)
 	return nil
 }
 
-func parseTemplates() (map[string]*template.Template, error) {
+// applySchedule drops expired content and, unless future is set, content
+// dated after now. It returns what remains along with every future-dated
+// item, whether or not it was kept.
+func applySchedule(posts []*Post, now time.Time, future bool) (live, scheduled []*Post) {
+	for _, post := range posts {
+		if !post.Expires.IsZero() && !now.Before(post.Expires) {
+			fmt.Printf("Skipping expired: %s\n", post.Source)
+			continue
+		}
+		if post.Date.After(now) {
+			scheduled = append(scheduled, post)
+			if !future {
+				continue
+			}
+		}
+		live = append(live, post)
+	}
+	return live, scheduled
+}
+
+// reportOmittedHTML warns about content whose raw HTML was left out of the
+// rendered page, which usually means an import lost embeds or tables.
+func reportOmittedHTML(posts []*Post) {
+	var omitted []string
+	for _, post := range posts {
+		if post.RawHTMLOmitted {
+			omitted = append(omitted, post.Source)
+		}
+	}
+	if len(omitted) == 0 {
+		return
+	}
+
+	fmt.Printf("Warning: raw HTML omitted from %d file(s); set markdown.unsafe in site.yml or unsafe: true in frontmatter to render it:\n", len(omitted))
+	for _, source := range omitted {
+		fmt.Printf("  %s\n", source)
+	}
+}
+
+// reportSanitized lists, per file, what the sanitizer removed.
+func reportSanitized(posts []*Post) {
+	for _, post := range posts {
+		if len(post.Sanitized) == 0 {
+			continue
+		}
+		fmt.Printf("Sanitized %s: removed %s\n", post.Source, strings.Join(post.Sanitized, ", "))
+	}
+}
+
+func reportScheduled(scheduled []*Post, included bool) {
+	if len(scheduled) == 0 {
+		return
+	}
+
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return scheduled[i].Date.Before(scheduled[j].Date)
+	})
+
+	fmt.Println()
+	if included {
+		fmt.Println("Future-dated content (included by --future):")
+	} else {
+		fmt.Println("Scheduled content (held back until its date):")
+	}
+	for _, post := range scheduled {
+		fmt.Printf("  %s  %s  %q\n", post.Date.Format("2006-01-02 15:04"), post.Source, post.Title)
+	}
+}
+
+func parseTemplates(templateDir string) (map[string]*template.Template, error) {
 	ver := version()
 	funcMap := template.FuncMap{
 		"formatDate": func(t time.Time) string {
@@ -624,13 +1203,23 @@ func parseTemplates() (map[string]*template.Template, error) {
 		"generator": func() string {
 			return "blog " + ver
 		},
+		"termURL": termURL,
 	}
 
 	pages := []string{"home.html", "post.html", "archive.html"}
-	templates := make(map[string]*template.Template, len(pages))
+	// Sites scaffolded before these templates existed keep building; the
+	// pages that need them are skipped instead.
+	optionalPages := []string{"taxonomy.html", "term.html", "page.html"}
+	templates := make(map[string]*template.Template, len(pages)+len(optionalPages))
 
 	baseFile := filepath.Join(templateDir, "base.html")
 
+	for _, page := range optionalPages {
+		if _, err := os.Stat(filepath.Join(templateDir, page)); err == nil {
+			pages = append(pages, page)
+		}
+	}
+
 	for _, page := range pages {
 		pageFile := filepath.Join(templateDir, page)
 		t, err := template.New("base.html").Funcs(funcMap).ParseFiles(baseFile, pageFile)
@@ -643,88 +1232,263 @@ func parseTemplates() (map[string]*template.Template, error) {
 	return templates, nil
 }
 
-func parsePosts() ([]*Post, error) {
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			meta.Meta,
-		),
-	)
+func parsePosts(md *markdownConverter, cache *postCache, site SiteConfig, opts buildOptions) ([]*Post, error) {
+	return parseContent(md, cache, site, site.ContentDir, "posts", opts)
+}
+
+// parsePages reads the standalone pages. The pages directory is optional.
+func parsePages(md *markdownConverter, cache *postCache, site SiteConfig, opts buildOptions) ([]*Post, error) {
+	if _, err := os.Stat(site.PagesDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return parseContent(md, cache, site, site.PagesDir, "pages", opts)
+}
+
+// parseContent converts every Markdown file and bundle in dir, assigning
+// each to section and a URL from the section's permalink pattern, and
+// returns them in filename order. Drafts are left out unless
+// opts.Drafts is set.
+func parseContent(md *markdownConverter, cache *postCache, site SiteConfig, dir, section string, opts buildOptions) ([]*Post, error) {
+	filenames, err := contentFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make([]*Post, len(filenames))
+	err = runJobs(opts.Jobs, len(filenames), func(i int, log io.Writer) error {
+		post, err := parsePost(md, cache, dir, section, filenames[i])
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", filenames[i], err)
+		}
+		if post.URL, err = permalink(site, section, post.Slug, post.Date); err != nil {
+			return fmt.Errorf("parsing %s: %w", filenames[i], err)
+		}
+		if post.Draft && !opts.Drafts {
+			fmt.Fprintf(log, "Skipping draft: %s\n", filepath.Join(dir, filenames[i]))
+			return nil
+		}
+		parsed[i] = post
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	var posts []*Post
+	for _, post := range parsed {
+		if post != nil {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
 
-	entries, err := os.ReadDir(contentDir)
+// contentFiles lists the Markdown files in dir, relative to it and in
+// filename order. A directory holding index.md is a bundle: the post plus
+// the files it uses.
+func contentFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("reading content dir: %w", err)
 	}
 
+	var filenames []string
 	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".md") {
+		if entry.IsDir() {
+			index := filepath.Join(entry.Name(), bundleIndex)
+			if _, err := os.Stat(filepath.Join(dir, index)); err == nil {
+				filenames = append(filenames, index)
+			}
 			continue
 		}
-
-		post, err := parsePost(md, entry.Name())
-		if err != nil {
-			return nil, fmt.Errorf("parsing %s: %w", entry.Name(), err)
+		if strings.HasSuffix(entry.Name(), ".md") {
+			filenames = append(filenames, entry.Name())
 		}
-		if post != nil {
-			posts = append(posts, post)
+	}
+	return filenames, nil
+}
+
+func withoutDrafts(posts []*Post) []*Post {
+	var published []*Post
+	for _, post := range posts {
+		if !post.Draft {
+			published = append(published, post)
 		}
 	}
+	return published
+}
 
-	return posts, nil
+func countCached(posts []*Post) int {
+	n := 0
+	for _, post := range posts {
+		if post.cached {
+			n++
+		}
+	}
+	return n
 }
 
-func parsePost(md goldmark.Markdown, filename string) (*Post, error) {
-	source, err := os.ReadFile(filepath.Join(contentDir, filename))
+// parsePost returns the post in dir/filename. Posts whose source is
+// unchanged since the last build come from the cache without running the
+// Markdown converter.
+func parsePost(md *markdownConverter, cache *postCache, dir, section, filename string) (*Post, error) {
+	path := filepath.Join(dir, filename)
+	source, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var buf bytes.Buffer
-	ctx := parser.NewContext()
-	if err := md.Convert(source, &buf, parser.WithContext(ctx)); err != nil {
-		return nil, fmt.Errorf("converting markdown: %w", err)
+	post, ok := cache.Get(path, source)
+	if ok {
+		post.cached = true
+	} else {
+		post, err = convertPost(md, section, filename, source)
+		if err != nil {
+			return nil, err
+		}
+		post.Source = path
+		if isBundleIndex(filename) {
+			post.Bundle = filepath.Dir(path)
+		}
+		cache.Put(path, source, post)
 	}
+	return post, nil
+}
 
+func convertPost(md *markdownConverter, section, filename string, source []byte) (*Post, error) {
+	ctx := parser.NewContext()
+	doc := md.parser.Parse(text.NewReader(source), parser.WithContext(ctx))
 	metaData := meta.Get(ctx)
 
-	if draft, ok := metaData["draft"]; ok {
-		if d, ok := draft.(bool); ok && d {
-			fmt.Printf("Skipping draft: %s\n", filename)
-			return nil, nil
-		}
+	render := md.Renderer(metaData)
+	_, explicitUnsafe := metaData["unsafe"].(bool)
+	var buf bytes.Buffer
+	if err := render.Render(&buf, source, doc); err != nil {
+		return nil, fmt.Errorf("converting markdown: %w", err)
+	}
+	content := buf.String()
+	var sanitized []string
+	if md.policy != nil {
+		content, sanitized = md.policy.Sanitize(content)
 	}
 
+	draft, _ := metaData["draft"].(bool)
+
 	title, _ := metaData["title"].(string)
 	if title == "" {
 		title = "Untitled"
 	}
 
 	description, _ := metaData["description"].(string)
+	tags := stringList(metaData["tags"])
+	categories := stringList(metaData["categories"])
 
-	var date time.Time
-	if d, ok := metaData["date"].(string); ok {
-		date, err = time.Parse("2006-01-02", d)
-		if err != nil {
-			return nil, fmt.Errorf("parsing date %q: %w", d, err)
-		}
-	} else if d, ok := metaData["date"].(time.Time); ok {
-		date = d
+	inSitemap := true
+	if s, ok := metaData["sitemap"].(bool); ok {
+		inSitemap = s
+	}
+
+	var toc template.HTML
+	if showTOC, ok := metaData["toc"].(bool); !ok || showTOC {
+		toc = tableOfContents(doc, source)
+	}
+
+	inMenu, _ := metaData["menu"].(bool)
+	weight, _ := metaData["weight"].(int)
+
+	date, err := parseDate(metaData["date"])
+	if err != nil {
+		return nil, fmt.Errorf("parsing date: %w", err)
+	}
+
+	expires, err := parseDate(metaData["expires"])
+	if err != nil {
+		return nil, fmt.Errorf("parsing expires: %w", err)
 	}
 
 	slug := deriveSlug(filename)
+	if s, ok := metaData["slug"].(string); ok && strings.TrimSpace(s) != "" {
+		slug = strings.TrimSpace(s)
+		if strings.ContainsAny(slug, `/\`) || slug == "." || slug == ".." {
+			return nil, fmt.Errorf("slug %q must be a single path segment", s)
+		}
+	}
+
+	var aliases []string
+	for _, alias := range stringList(metaData["aliases"]) {
+		p, err := normalizeAlias(alias)
+		if err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, p)
+	}
 
 	return &Post{
 		Title:       title,
 		Slug:        slug,
+		Section:     section,
 		Date:        date,
+		Expires:     expires,
 		Description: description,
-		Content:     template.HTML(buf.String()),
-		URL:         "/posts/" + slug + "/",
+		Tags:        tags,
+		Categories:  categories,
+		Content:     template.HTML(content),
+		Aliases:     aliases,
+		Draft:       draft,
+		Sitemap:     inSitemap,
+		Menu:        inMenu,
+		Weight:      weight,
+
+		RawHTMLOmitted:  render == md.safe && !explicitUnsafe && hasRawHTML(doc),
+		Sanitized:       sanitized,
+		TableOfContents: toc,
 	}, nil
 }
 
+// parseDate reads a frontmatter date written as YYYY-MM-DD or as a full
+// RFC 3339 timestamp. A missing value is the zero time.
+func parseDate(v interface{}) (time.Time, error) {
+	switch d := v.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return d, nil
+	case string:
+		if t, err := time.Parse("2006-01-02", d); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.RFC3339, d)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not YYYY-MM-DD or RFC 3339", d)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
+// stringList accepts a frontmatter value written either as a YAML list or
+// as a single string and returns its non-empty entries.
+func stringList(v interface{}) []string {
+	var out []string
+	switch v := v.(type) {
+	case string:
+		if s := strings.TrimSpace(v); s != "" {
+			out = append(out, s)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s := strings.TrimSpace(fmt.Sprint(item)); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
 func deriveSlug(filename string) string {
+	if isBundleIndex(filename) {
+		filename = filepath.Dir(filename)
+	}
 	name := strings.TrimSuffix(filename, ".md")
 	if len(name) > 11 && name[4] == '-' && name[7] == '-' && name[10] == '-' {
 		name = name[11:]
@@ -732,50 +1496,150 @@ func deriveSlug(filename string) string {
 	return name
 }
 
-func generatePostPages(templates map[string]*template.Template, site SiteConfig, posts []*Post) error {
-	for _, post := range posts {
-		dir := filepath.Join(outputDir, "posts", post.Slug)
-		if err := os.MkdirAll(dir, 0o755); err != nil {
+func generatePostPages(out *outputWriter, templates map[string]*template.Template, site SiteConfig, posts []*Post, jobs int) error {
+	return runJobs(jobs, len(posts), func(i int, log io.Writer) error {
+		post := posts[i]
+
+		var buf bytes.Buffer
+		if err := templates["post.html"].Execute(&buf, PostPage{Site: site, Post: post}); err != nil {
+			return fmt.Errorf("executing post template for %s: %w", post.Slug, err)
+		}
+
+		rel := outputPath(post.URL)
+		if err := out.WriteFile(rel, buf.Bytes()); err != nil {
 			return err
 		}
 
-		f, err := os.Create(filepath.Join(dir, "index.html"))
-		if err != nil {
+		fmt.Fprintf(log, "Generated: %s\n", rel)
+		return nil
+	})
+}
+
+// generatedFiles and generatedDirs are the output the generator writes
+// besides posts and pages, which no post, page or alias may replace.
+var (
+	generatedFiles = map[string]bool{
+		"index.html":  true,
+		"feed.xml":    true,
+		"atom.xml":    true,
+		"feed.json":   true,
+		"sitemap.xml": true,
+		"robots.txt":  true,
+	}
+	generatedDirs = map[string]bool{
+		"archive":    true,
+		"categories": true,
+		"page":       true,
+		"tags":       true,
+	}
+)
+
+// isGeneratedPath reports whether file, relative to the output directory,
+// is or may be written by the generator itself.
+func isGeneratedPath(file string) bool {
+	dir, _, nested := strings.Cut(file, "/")
+	return generatedFiles[file] || (nested && generatedDirs[dir])
+}
+
+func generateStaticPages(out *outputWriter, templates map[string]*template.Template, site SiteConfig, pages []*Post) error {
+	if len(pages) == 0 {
+		return nil
+	}
+
+	t, ok := templates["page.html"]
+	if !ok {
+		return fmt.Errorf("%s has pages but %s is missing", site.PagesDir, filepath.Join(site.TemplateDir, "page.html"))
+	}
+
+	for _, page := range pages {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, StaticPage{Site: site, Page: page}); err != nil {
+			return fmt.Errorf("executing page template for %s: %w", page.Slug, err)
+		}
+
+		rel := outputPath(page.URL)
+		if err := out.WriteFile(rel, buf.Bytes()); err != nil {
 			return err
 		}
 
-		err = templates["post.html"].Execute(f, PostPage{Site: site, Post: post})
-		f.Close()
-		if err != nil {
-			return fmt.Errorf("executing post template for %s: %w", post.Slug, err)
+		fmt.Printf("Generated: %s\n", rel)
+	}
+	return nil
+}
+
+// menuPages returns the pages that asked to appear in the nav, ordered by
+// weight and then title.
+func menuPages(pages []*Post) []*Post {
+	var menu []*Post
+	for _, page := range pages {
+		if page.Menu {
+			menu = append(menu, page)
+		}
+	}
+	sort.SliceStable(menu, func(i, j int) bool {
+		if menu[i].Weight != menu[j].Weight {
+			return menu[i].Weight < menu[j].Weight
+		}
+		return menu[i].Title < menu[j].Title
+	})
+	return menu
+}
+
+func generateHomePage(out *outputWriter, templates map[string]*template.Template, site SiteConfig, posts []*Post) error {
+	totalPages := (len(posts) + site.PostsPerPage - 1) / site.PostsPerPage
+	if totalPages == 0 {
+		totalPages = 1
+	}
+
+	for page := 1; page <= totalPages; page++ {
+		start := (page - 1) * site.PostsPerPage
+		end := start + site.PostsPerPage
+		if end > len(posts) {
+			end = len(posts)
 		}
 
-		fmt.Printf("Generated: posts/%s/index.html\n", post.Slug)
+		paginator := &Paginator{Page: page, TotalPages: totalPages}
+		if page > 1 {
+			paginator.PrevURL = homePageURL(page - 1)
+		}
+		if page < totalPages {
+			paginator.NextURL = homePageURL(page + 1)
+		}
+
+		if err := generateHomePageN(out, templates, site, posts[start:end], paginator); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func generateHomePage(templates map[string]*template.Template, site SiteConfig, posts []*Post) error {
-	recent := posts
-	if len(recent) > postsPerPage {
-		recent = recent[:postsPerPage]
+func generateHomePageN(out *outputWriter, templates map[string]*template.Template, site SiteConfig, posts []*Post, paginator *Paginator) error {
+	rel := "index.html"
+	if paginator.Page > 1 {
+		rel = filepath.Join("page", strconv.Itoa(paginator.Page), "index.html")
 	}
 
-	f, err := os.Create(filepath.Join(outputDir, "index.html"))
-	if err != nil {
-		return err
+	var buf bytes.Buffer
+	if err := templates["home.html"].Execute(&buf, HomePage{Site: site, Posts: posts, Paginator: paginator}); err != nil {
+		return fmt.Errorf("executing home template for page %d: %w", paginator.Page, err)
 	}
-	defer f.Close()
 
-	if err := templates["home.html"].Execute(f, HomePage{Site: site, Posts: recent}); err != nil {
-		return fmt.Errorf("executing home template: %w", err)
+	if err := out.WriteFile(rel, buf.Bytes()); err != nil {
+		return err
 	}
 
-	fmt.Println("Generated: index.html")
+	fmt.Printf("Generated: %s\n", filepath.ToSlash(rel))
 	return nil
 }
 
-func generateArchivePage(templates map[string]*template.Template, site SiteConfig, posts []*Post) error {
+func homePageURL(page int) string {
+	if page == 1 {
+		return "/"
+	}
+	return "/page/" + strconv.Itoa(page) + "/"
+}
+
+func generateArchivePage(out *outputWriter, templates map[string]*template.Template, site SiteConfig, posts []*Post) error {
 	yearMap := make(map[int][]*Post)
 	for _, post := range posts {
 		year := post.Date.Year()
@@ -790,40 +1654,44 @@ func generateArchivePage(templates map[string]*template.Template, site SiteConfi
 		return years[i].Year > years[j].Year
 	})
 
-	dir := filepath.Join(outputDir, "archive")
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return err
+	var buf bytes.Buffer
+	if err := templates["archive.html"].Execute(&buf, ArchivePage{Site: site, Years: years}); err != nil {
+		return fmt.Errorf("executing archive template: %w", err)
 	}
 
-	f, err := os.Create(filepath.Join(dir, "index.html"))
-	if err != nil {
+	if err := out.WriteFile(filepath.Join("archive", "index.html"), buf.Bytes()); err != nil {
 		return err
 	}
-	defer f.Close()
-
-	if err := templates["archive.html"].Execute(f, ArchivePage{Site: site, Years: years}); err != nil {
-		return fmt.Errorf("executing archive template: %w", err)
-	}
 
 	fmt.Println("Generated: archive/index.html")
 	return nil
 }
 
-func generateRSSFeed(site SiteConfig, posts []*Post) error {
-	feedPosts := posts
-	if len(feedPosts) > postsInFeed {
-		feedPosts = feedPosts[:postsInFeed]
+func generateRSSFeed(out *outputWriter, site SiteConfig, posts []*Post) error {
+	if err := writeRSSFeed(out, "feed.xml", site.Title, site.URL, site.Description, site, posts); err != nil {
+		return err
 	}
 
+	fmt.Println("Generated: feed.xml")
+	return nil
+}
+
+// writeRSSFeed encodes the feed selection of posts as an RSS 2.0 channel
+// with the given title, link and description and writes it to rel.
+func writeRSSFeed(out *outputWriter, rel, title, link, description string, site SiteConfig, posts []*Post) error {
 	var items []RSSItem
-	for _, post := range feedPosts {
-		items = append(items, RSSItem{
+	for _, post := range feedPosts(site, posts) {
+		item := RSSItem{
 			Title:       post.Title,
 			Link:        site.URL + post.URL,
 			Description: post.Description,
 			PubDate:     post.Date.Format(time.RFC1123Z),
 			GUID:        site.URL + post.URL,
-		})
+		}
+		if site.FeedFullContent {
+			item.Content = &RSSContent{HTML: absoluteURLs(string(post.Content), site.URL+post.URL)}
+		}
+		items = append(items, item)
 	}
 
 	var lastBuild string
@@ -834,61 +1702,64 @@ func generateRSSFeed(site SiteConfig, posts []*Post) error {
 	feed := RSSFeed{
 		Version: "2.0",
 		Channel: RSSChannel{
-			Title:       site.Title,
-			Link:        site.URL,
-			Description: site.Description,
+			Title:       title,
+			Link:        link,
+			Description: description,
 			LastBuild:   lastBuild,
 			Items:       items,
 		},
 	}
-
-	f, err := os.Create(filepath.Join(outputDir, "feed.xml"))
-	if err != nil {
-		return err
+	if site.FeedFullContent {
+		feed.ContentNS = "http://purl.org/rss/1.0/modules/content/"
 	}
-	defer f.Close()
 
-	if _, err := f.WriteString(xml.Header); err != nil {
-		return err
-	}
-
-	enc := xml.NewEncoder(f)
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
 	enc.Indent("", "  ")
 	if err := enc.Encode(feed); err != nil {
 		return fmt.Errorf("encoding RSS: %w", err)
 	}
+	buf.WriteString("\n")
 
-	fmt.Println("Generated: feed.xml")
-	return nil
+	return out.WriteFile(rel, buf.Bytes())
+}
+
+// feedPosts selects the posts every feed format publishes: the newest
+// site.PostsInFeed of posts, which are expected to be sorted newest first.
+func feedPosts(site SiteConfig, posts []*Post) []*Post {
+	if len(posts) > site.PostsInFeed {
+		return posts[:site.PostsInFeed]
+	}
+	return posts
 }
 
-func copyStaticFiles() error {
+func copyStaticFiles(out *outputWriter, staticDir string) error {
 	if _, err := os.Stat(staticDir); err == nil {
-		if err := copyDir(staticDir, outputDir); err != nil {
+		if err := copyDir(out, staticDir, "."); err != nil {
 			return fmt.Errorf("copying static files: %w", err)
 		}
 	}
 	return nil
 }
 
-func copyDir(srcDir, destBase string) error {
+// copyDir copies every file under srcDir to destRel, a path relative to the
+// output directory.
+func copyDir(out *outputWriter, srcDir, destRel string) error {
 	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if d.IsDir() {
+			return nil
+		}
 
 		relPath, err := filepath.Rel(srcDir, path)
 		if err != nil {
 			return err
 		}
 
-		destPath := filepath.Join(destBase, relPath)
-
-		if d.IsDir() {
-			return os.MkdirAll(destPath, 0o755)
-		}
-
-		return copyFile(path, destPath)
+		return out.CopyFile(path, filepath.Join(destRel, relPath))
 	})
 }
 
@@ -907,20 +1778,3 @@ func cleanDir(dir string) error {
 	}
 	return nil
 }
-
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, in)
-	return err
-}
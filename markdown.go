@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+const defaultHighlightStyle = "github"
+
+// MarkdownConfig toggles the optional Markdown extensions. Every extension
+// is on unless site.yml turns it off, so the fields are pointers to tell
+// "unset" apart from false.
+type MarkdownConfig struct {
+	Tables          *bool `yaml:"tables"`
+	Strikethrough   *bool `yaml:"strikethrough"`
+	Autolinks       *bool `yaml:"autolinks"`
+	TaskLists       *bool `yaml:"task_lists"`
+	Footnotes       *bool `yaml:"footnotes"`
+	DefinitionLists *bool `yaml:"definition_lists"`
+	Typographer     *bool `yaml:"typographer"`
+
+	// Unsafe renders raw HTML embedded in Markdown instead of replacing it
+	// with a comment. Posts can override it with `unsafe:` frontmatter.
+	Unsafe bool `yaml:"unsafe"`
+}
+
+func enabled(b *bool) bool {
+	return b == nil || *b
+}
+
+type HighlightConfig struct {
+	Style       string `yaml:"style"`
+	LineNumbers bool   `yaml:"line_numbers"`
+}
+
+// markdownConverter parses posts once and renders them with one of two
+// otherwise identical renderers, depending on whether the post may contain
+// raw HTML. The choice can only be made after parsing, since a post's
+// frontmatter can override the site default.
+type markdownConverter struct {
+	parser parser.Parser
+	safe   renderer.Renderer
+	unsafe renderer.Renderer
+
+	// allowRaw is the site-wide default for posts without `unsafe:`.
+	allowRaw bool
+
+	// policy cleans rendered content; nil unless sanitize.enabled is set.
+	policy *sanitizePolicy
+}
+
+func newMarkdown(site SiteConfig) *markdownConverter {
+	safe := newGoldmark(site)
+	unsafe := newGoldmark(site, goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()))
+	return &markdownConverter{
+		parser:   safe.Parser(),
+		safe:     safe.Renderer(),
+		unsafe:   unsafe.Renderer(),
+		allowRaw: site.Markdown.Unsafe,
+		policy:   newSanitizePolicy(site.Sanitize),
+	}
+}
+
+// Renderer returns the renderer for a post whose frontmatter holds meta.
+func (c *markdownConverter) Renderer(meta map[string]interface{}) renderer.Renderer {
+	allowRaw := c.allowRaw
+	if v, ok := meta["unsafe"].(bool); ok {
+		allowRaw = v
+	}
+	if allowRaw {
+		return c.unsafe
+	}
+	return c.safe
+}
+
+// newGoldmark builds the Markdown converter used for every post and page.
+// Headings get unique IDs for deep links and the table of contents. Code
+// blocks are highlighted at build time with CSS classes rather than inline
+// styles, so the colour scheme comes from the stylesheet written by
+// `blog gen-css`.
+func newGoldmark(site SiteConfig, opts ...goldmark.Option) goldmark.Markdown {
+	extensions := []goldmark.Extender{
+		meta.Meta,
+		highlighting.NewHighlighting(
+			highlighting.WithFormatOptions(
+				chromahtml.WithClasses(true),
+				chromahtml.WithLineNumbers(site.Highlight.LineNumbers),
+			),
+		),
+	}
+
+	cfg := site.Markdown
+	optional := []struct {
+		on  *bool
+		ext goldmark.Extender
+	}{
+		{cfg.Tables, extension.Table},
+		{cfg.Strikethrough, extension.Strikethrough},
+		{cfg.Autolinks, extension.Linkify},
+		{cfg.TaskLists, extension.TaskList},
+		{cfg.Footnotes, extension.Footnote},
+		{cfg.DefinitionLists, extension.DefinitionList},
+		{cfg.Typographer, extension.Typographer},
+	}
+	for _, o := range optional {
+		if enabled(o.on) {
+			extensions = append(extensions, o.ext)
+		}
+	}
+
+	opts = append(opts,
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithExtensions(extensions...),
+	)
+	return goldmark.New(opts...)
+}
+
+// hasRawHTML reports whether doc contains inline or block HTML, which the
+// safe renderer omits.
+func hasRawHTML(doc ast.Node) bool {
+	found := false
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && (n.Kind() == ast.KindHTMLBlock || n.Kind() == ast.KindRawHTML) {
+			found = true
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return found
+}
+
+// highlightStyle looks up a chroma style by name, rejecting unknown names
+// instead of silently falling back to chroma's default.
+func highlightStyle(name string) (*chroma.Style, error) {
+	style, ok := styles.Registry[strings.ToLower(name)]
+	if !ok {
+		names := styles.Names()
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown highlight style %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return style, nil
+}
+
+func writeHighlightCSS(w io.Writer, name string) error {
+	style, err := highlightStyle(name)
+	if err != nil {
+		return err
+	}
+	return chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(w, style)
+}
+
+func runGenCSS(args []string) error {
+	fs := flag.NewFlagSet("gen-css", flag.ExitOnError)
+	style := fs.String("style", "", "Highlight style (default: highlight.style from site.yml)")
+	output := fs.String("output", "", "Write the stylesheet to this file instead of stdout")
+	var opts siteOptions
+	siteFlags(fs, &opts)
+	fs.Parse(args)
+
+	if *style == "" {
+		*style = defaultHighlightStyle
+		if _, err := os.Stat(opts.configPath()); err == nil {
+			site, err := loadConfig(opts)
+			if err != nil {
+				return err
+			}
+			*style = site.Highlight.Style
+		}
+	}
+
+	if *output == "" {
+		return writeHighlightCSS(os.Stdout, *style)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := writeHighlightCSS(f, *style); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Println(*output)
+	return nil
+}
+
+// tableOfContents renders the headings in doc as nested lists of links to
+// their generated IDs. Levels are relative, so a post whose sections start
+// at h2 gets the same structure as one starting at h1. Posts with fewer than
+// two headings get no table of contents.
+func tableOfContents(doc ast.Node, source []byte) template.HTML {
+	type entry struct {
+		level int
+		id    string
+		text  string
+	}
+
+	var entries []entry
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		id, _ := heading.AttributeString("id")
+		idBytes, _ := id.([]byte)
+		entries = append(entries, entry{
+			level: heading.Level,
+			id:    string(idBytes),
+			text:  nodeText(heading, source),
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	if len(entries) < 2 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	var open []int // heading levels of the currently open lists
+	for _, e := range entries {
+		for len(open) > 0 && e.level < open[len(open)-1] {
+			buf.WriteString("</li></ul>")
+			open = open[:len(open)-1]
+		}
+		if len(open) > 0 && e.level == open[len(open)-1] {
+			buf.WriteString("</li>")
+		} else {
+			buf.WriteString("<ul>")
+			open = append(open, e.level)
+		}
+		fmt.Fprintf(&buf, `<li><a href="#%s">%s</a>`, html.EscapeString(e.id), html.EscapeString(e.text))
+	}
+	for range open {
+		buf.WriteString("</li></ul>")
+	}
+	return template.HTML(buf.String())
+}
+
+// nodeText returns the plain text of n's inline children, dropping any
+// emphasis, links or code formatting.
+func nodeText(n ast.Node, source []byte) string {
+	var buf bytes.Buffer
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			buf.Write(c.Segment.Value(source))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(c.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return buf.String()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultPermalinks are the URL patterns used for sections site.yml does
+// not configure.
+var defaultPermalinks = map[string]string{
+	"posts": "/posts/:slug/",
+	"pages": "/:slug/",
+}
+
+var permalinkToken = regexp.MustCompile(`:[a-z]+`)
+
+var permalinkTokens = map[string]bool{
+	":year":    true,
+	":month":   true,
+	":day":     true,
+	":slug":    true,
+	":section": true,
+}
+
+// validatePermalinks rejects patterns that cannot give every post its own
+// URL or that use unknown tokens.
+func validatePermalinks(patterns map[string]string) error {
+	for section, pattern := range patterns {
+		if _, ok := defaultPermalinks[section]; !ok {
+			return fmt.Errorf("permalinks: unknown section %q (expected posts or pages)", section)
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("permalinks.%s: %q must start with /", section, pattern)
+		}
+		if !strings.HasSuffix(pattern, "/") && !strings.HasSuffix(pattern, ".html") {
+			return fmt.Errorf("permalinks.%s: %q must end with / or .html", section, pattern)
+		}
+		if !strings.Contains(pattern, ":slug") {
+			return fmt.Errorf("permalinks.%s: %q must contain :slug", section, pattern)
+		}
+		for _, token := range permalinkToken.FindAllString(pattern, -1) {
+			if !permalinkTokens[token] {
+				return fmt.Errorf("permalinks.%s: unknown token %s (available: :year, :month, :day, :slug, :section)", section, token)
+			}
+		}
+	}
+	return nil
+}
+
+// permalink returns the URL path of content in section by expanding the
+// section's pattern. With ugly_urls set, a URL that would end in a
+// directory ends in .html instead: /posts/my-post.html rather than
+// /posts/my-post/.
+func permalink(site SiteConfig, section, slug string, date time.Time) (string, error) {
+	if slug == "" || slug == "." || slug == ".." {
+		return "", fmt.Errorf("%q is not a usable slug", slug)
+	}
+	pattern := site.Permalinks[section]
+	if pattern == "" {
+		pattern = defaultPermalinks[section]
+	}
+
+	var err error
+	url := permalinkToken.ReplaceAllStringFunc(pattern, func(token string) string {
+		switch token {
+		case ":slug":
+			return slug
+		case ":section":
+			return section
+		}
+		if date.IsZero() {
+			err = fmt.Errorf("permalinks.%s uses %s but there is no date", section, token)
+			return token
+		}
+		switch token {
+		case ":year":
+			return date.Format("2006")
+		case ":month":
+			return date.Format("01")
+		case ":day":
+			return date.Format("02")
+		}
+		return token
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if site.UglyURLs && strings.HasSuffix(url, "/") && url != "/" {
+		url = strings.TrimSuffix(url, "/") + ".html"
+	}
+	return url, nil
+}
+
+// outputPath is the file, relative to the output directory, served at the
+// URL path url: directories are served by their index.html. The path is
+// cleaned first, so /posts//x/ and /./ map to the files a server would use.
+func outputPath(url string) string {
+	rel := strings.TrimPrefix(path.Clean("/"+url), "/")
+	if rel == "" || strings.HasSuffix(url, "/") {
+		return path.Join(rel, "index.html")
+	}
+	return rel
+}
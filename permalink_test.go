@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/", "index.html"},
+		{"/./", "index.html"},
+		{"//", "index.html"},
+		{"/posts/x/", "posts/x/index.html"},
+		{"/posts//x/", "posts/x/index.html"},
+		{"/posts/./x/", "posts/x/index.html"},
+		{"/posts/x.html", "posts/x.html"},
+		{"/posts/../../x.html", "x.html"},
+	}
+	for _, tt := range tests {
+		if got := outputPath(tt.url); got != tt.want {
+			t.Errorf("outputPath(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPermalink(t *testing.T) {
+	date := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	site := SiteConfig{Permalinks: map[string]string{"posts": "/:year/:month/:day/:slug/"}}
+
+	tests := []struct {
+		site    SiteConfig
+		section string
+		slug    string
+		date    time.Time
+		want    string
+		wantErr bool
+	}{
+		{site: SiteConfig{}, section: "posts", slug: "trip", want: "/posts/trip/"},
+		{site: SiteConfig{}, section: "pages", slug: "about", want: "/about/"},
+		{site: SiteConfig{UglyURLs: true}, section: "posts", slug: "trip", want: "/posts/trip.html"},
+		{site: site, section: "posts", slug: "trip", date: date, want: "/2026/01/15/trip/"},
+		{site: site, section: "posts", slug: "trip", wantErr: true},
+		{site: SiteConfig{}, section: "pages", slug: "", wantErr: true},
+		{site: SiteConfig{}, section: "pages", slug: ".", wantErr: true},
+		{site: SiteConfig{}, section: "pages", slug: "..", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := permalink(tt.site, tt.section, tt.slug, tt.date)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("permalink(%s, %q) = %q, want an error", tt.section, tt.slug, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("permalink(%s, %q) = %q, %v; want %q", tt.section, tt.slug, got, err, tt.want)
+		}
+	}
+}
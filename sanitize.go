@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// SanitizeConfig extends the built-in allowlist used to clean rendered
+// content. Elements and attributes listed here are allowed in addition to
+// the defaults; Schemes replaces the default list of URL schemes.
+type SanitizeConfig struct {
+	Enabled    bool                `yaml:"enabled"`
+	Elements   []string            `yaml:"elements"`
+	Attributes map[string][]string `yaml:"attributes"`
+	Schemes    []string            `yaml:"schemes"`
+}
+
+var defaultSanitizeElements = []string{
+	"a", "abbr", "b", "blockquote", "br", "caption", "cite", "code", "col",
+	"colgroup", "dd", "del", "details", "dfn", "div", "dl", "dt", "em",
+	"figcaption", "figure", "h1", "h2", "h3", "h4", "h5", "h6", "hr", "i",
+	"img", "input", "ins", "kbd", "li", "mark", "ol", "p", "pre", "q", "s",
+	"samp", "small", "span", "strong", "sub", "summary", "sup", "table",
+	"tbody", "td", "tfoot", "th", "thead", "time", "tr", "u", "ul",
+}
+
+var defaultSanitizeAttributes = map[string][]string{
+	"*":          {"id", "class", "title", "lang", "dir", "role"},
+	"a":          {"href", "rel", "name"},
+	"img":        {"src", "alt", "width", "height", "srcset", "sizes", "loading"},
+	"input":      {"type", "checked", "disabled"},
+	"ol":         {"start", "reversed"},
+	"li":         {"value"},
+	"td":         {"colspan", "rowspan", "align"},
+	"th":         {"colspan", "rowspan", "align", "scope"},
+	"time":       {"datetime"},
+	"q":          {"cite"},
+	"blockquote": {"cite"},
+	"details":    {"open"},
+}
+
+// cellAlignStyle matches the only inline style Markdown tables produce.
+var cellAlignStyle = regexp.MustCompile(`^text-align:\s*(left|right|center);?$`)
+
+var defaultSanitizeSchemes = []string{"http", "https", "mailto"}
+
+// dropWithContent lists elements whose content is meaningless or dangerous
+// once the element itself is gone, so the whole subtree is removed.
+var dropWithContent = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"template": true,
+	"noscript": true,
+	"object":   true,
+	"embed":    true,
+	"iframe":   true,
+	"title":    true,
+	"head":     true,
+	"svg":      true,
+	"math":     true,
+}
+
+var urlAttributes = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"cite":   true,
+	"action": true,
+	"poster": true,
+	"srcset": true,
+}
+
+// sanitizePolicy is an allowlist of elements, attributes and URL schemes.
+type sanitizePolicy struct {
+	elements   map[string]bool
+	attributes map[string]map[string]bool
+	schemes    map[string]bool
+}
+
+// newSanitizePolicy returns nil when sanitizing is disabled.
+func newSanitizePolicy(cfg SanitizeConfig) *sanitizePolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+	return buildSanitizePolicy(cfg)
+}
+
+func buildSanitizePolicy(cfg SanitizeConfig) *sanitizePolicy {
+	p := &sanitizePolicy{
+		elements:   make(map[string]bool),
+		attributes: make(map[string]map[string]bool),
+		schemes:    make(map[string]bool),
+	}
+	for _, el := range append(defaultSanitizeElements, cfg.Elements...) {
+		p.elements[strings.ToLower(el)] = true
+	}
+	for _, attrs := range []map[string][]string{defaultSanitizeAttributes, cfg.Attributes} {
+		for el, names := range attrs {
+			el = strings.ToLower(el)
+			if p.attributes[el] == nil {
+				p.attributes[el] = make(map[string]bool)
+			}
+			for _, name := range names {
+				p.attributes[el][strings.ToLower(name)] = true
+			}
+		}
+	}
+	schemes := cfg.Schemes
+	if len(schemes) == 0 {
+		schemes = defaultSanitizeSchemes
+	}
+	for _, scheme := range schemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+	return p
+}
+
+// Sanitize strips everything from content that the policy does not allow
+// and describes what it removed, one entry per kind of removal with a count,
+// in a stable order.
+func (p *sanitizePolicy) Sanitize(content string) (string, []string) {
+	var out bytes.Buffer
+	removed := make(map[string]int)
+
+	z := nethtml.NewTokenizer(strings.NewReader(content))
+	skipping := "" // element whose subtree is being dropped
+	skipDepth := 0 // nesting of that element inside itself
+
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			if z.Err() != io.EOF {
+				removed["unparseable HTML"]++
+			}
+			break
+		}
+		tok := z.Token()
+		name := tok.Data
+
+		if skipping != "" {
+			switch {
+			case tt == nethtml.StartTagToken && name == skipping:
+				skipDepth++
+			case tt == nethtml.EndTagToken && name == skipping:
+				skipDepth--
+				if skipDepth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case nethtml.TextToken:
+			out.WriteString(html.EscapeString(tok.Data))
+
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			if !p.elements[name] {
+				removed[fmt.Sprintf("<%s> element", name)]++
+				if dropWithContent[name] && tt == nethtml.StartTagToken {
+					skipping, skipDepth = name, 1
+				}
+				continue
+			}
+			if name == "img" && isTrackingPixel(tok.Attr) {
+				removed["tracking pixel"]++
+				continue
+			}
+			out.WriteString("<" + name)
+			for _, attr := range tok.Attr {
+				if reason := p.rejectAttr(name, attr); reason != "" {
+					removed[reason]++
+					continue
+				}
+				fmt.Fprintf(&out, ` %s="%s"`, attr.Key, html.EscapeString(attr.Val))
+			}
+			out.WriteString(">")
+
+		case nethtml.EndTagToken:
+			if p.elements[name] {
+				out.WriteString("</" + name + ">")
+			}
+
+		case nethtml.CommentToken, nethtml.DoctypeToken:
+			// Dropped silently; they never affect what readers see.
+		}
+	}
+
+	var report []string
+	for what, n := range removed {
+		if n > 1 {
+			what = fmt.Sprintf("%s (x%d)", what, n)
+		}
+		report = append(report, what)
+	}
+	sort.Strings(report)
+	return out.String(), report
+}
+
+// rejectAttr returns why attr may not appear on element, or "" if it may.
+func (p *sanitizePolicy) rejectAttr(element string, attr nethtml.Attribute) string {
+	key := strings.ToLower(attr.Key)
+	if key == "style" && (element == "td" || element == "th") && cellAlignStyle.MatchString(attr.Val) {
+		return ""
+	}
+	if attr.Namespace != "" || (!p.attributes["*"][key] && !p.attributes[element][key]) {
+		return fmt.Sprintf("%s attribute on <%s>", key, element)
+	}
+	if urlAttributes[key] && !p.allowedURL(key, attr.Val) {
+		return fmt.Sprintf("disallowed URL in %s on <%s>", key, element)
+	}
+	return ""
+}
+
+func (p *sanitizePolicy) allowedURL(key, val string) bool {
+	candidates := []string{val}
+	if key == "srcset" {
+		candidates = nil
+		for _, part := range strings.Split(val, ",") {
+			if fields := strings.Fields(part); len(fields) > 0 {
+				candidates = append(candidates, fields[0])
+			}
+		}
+	}
+	for _, c := range candidates {
+		u, err := url.Parse(strings.TrimSpace(c))
+		if err != nil {
+			return false
+		}
+		// ref: links are resolved to post URLs later in the build.
+		if u.Scheme != "" && u.Scheme != refScheme && !p.schemes[strings.ToLower(u.Scheme)] {
+			return false
+		}
+	}
+	return true
+}
+
+// isTrackingPixel reports whether an image is sized to be invisible, the
+// usual shape of a web beacon left behind by imported newsletters.
+func isTrackingPixel(attrs []nethtml.Attribute) bool {
+	var width, height string
+	for _, a := range attrs {
+		switch strings.ToLower(a.Key) {
+		case "width":
+			width = strings.TrimSuffix(strings.TrimSpace(a.Val), "px")
+		case "height":
+			height = strings.TrimSuffix(strings.TrimSpace(a.Val), "px")
+		}
+	}
+	tiny := func(v string) bool { return v == "0" || v == "1" }
+	return tiny(width) && tiny(height)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	policy := buildSanitizePolicy(SanitizeConfig{})
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		removed []string
+	}{
+		{
+			name: "allowed markup is kept",
+			in:   `<p class="note">Some <a href="https://example.com/" title="x">text</a></p>`,
+			want: `<p class="note">Some <a href="https://example.com/" title="x">text</a></p>`,
+		},
+		{
+			name:    "javascript URL",
+			in:      `<a href="javascript:alert(1)">x</a>`,
+			want:    `<a>x</a>`,
+			removed: []string{"disallowed URL in href on <a>"},
+		},
+		{
+			name:    "mixed-case scheme",
+			in:      `<a href="JaVaScRiPt:alert(1)">x</a>`,
+			want:    `<a>x</a>`,
+			removed: []string{"disallowed URL in href on <a>"},
+		},
+		{
+			name:    "scheme with leading whitespace",
+			in:      `<a href="  javascript:alert(1)">x</a>`,
+			want:    `<a>x</a>`,
+			removed: []string{"disallowed URL in href on <a>"},
+		},
+		{
+			name:    "scheme with an entity-encoded tab",
+			in:      `<a href="java&#9;script:alert(1)">x</a>`,
+			want:    `<a>x</a>`,
+			removed: []string{"disallowed URL in href on <a>"},
+		},
+		{
+			name:    "data URL in an image",
+			in:      `<img src="data:image/svg+xml,x" alt="a">`,
+			want:    `<img alt="a">`,
+			removed: []string{"disallowed URL in src on <img>"},
+		},
+		{
+			name:    "javascript URL in srcset",
+			in:      `<img src="/a.png" srcset="/a.png 1x, javascript:alert(1) 2x">`,
+			want:    `<img src="/a.png">`,
+			removed: []string{"disallowed URL in srcset on <img>"},
+		},
+		{
+			name: "ref links survive",
+			in:   `<a href="ref:other-post#part">x</a>`,
+			want: `<a href="ref:other-post#part">x</a>`,
+		},
+		{
+			name:    "event handlers",
+			in:      `<p onclick="steal()" ONMOUSEOVER="steal()">x</p><img src="/a.png" onerror="steal()">`,
+			want:    `<p>x</p><img src="/a.png">`,
+			removed: []string{"onclick attribute on <p>", "onerror attribute on <img>", "onmouseover attribute on <p>"},
+		},
+		{
+			name:    "script is dropped with its content",
+			in:      `<p>a</p><script>alert("<p>b</p>")</script><p>c</p>`,
+			want:    `<p>a</p><p>c</p>`,
+			removed: []string{"<script> element"},
+		},
+		{
+			name:    "nested drop-with-content elements",
+			in:      `<object><object><p>inner</p></object><p>still inside</p></object><p>after</p>`,
+			want:    `<p>after</p>`,
+			removed: []string{"<object> element"},
+		},
+		{
+			name:    "style, iframe and svg are dropped with their content",
+			in:      `<style>p{}</style><iframe src="https://example.com/">fallback</iframe><svg><script>x</script></svg>ok`,
+			want:    `ok`,
+			removed: []string{"<iframe> element", "<style> element", "<svg> element"},
+		},
+		{
+			name:    "unknown elements are unwrapped",
+			in:      `<form action="/x"><p>kept</p></form>`,
+			want:    `<p>kept</p>`,
+			removed: []string{"<form> element"},
+		},
+		{
+			name:    "tracking pixels",
+			in:      `<p>x<img src="https://t.example/p.gif" width="1" height="1"><img src="/a.png" width="0px" height="0px"></p>`,
+			want:    `<p>x</p>`,
+			removed: []string{"tracking pixel (x2)"},
+		},
+		{
+			name: "small images that are not pixels",
+			in:   `<img src="/icon.png" width="1" height="16">`,
+			want: `<img src="/icon.png" width="1" height="16">`,
+		},
+		{
+			name: "table cell alignment",
+			in:   `<table><tr><th style="text-align:center">a</th><td style="text-align: right;">b</td></tr></table>`,
+			want: `<table><tr><th style="text-align:center">a</th><td style="text-align: right;">b</td></tr></table>`,
+		},
+		{
+			name:    "other styles on table cells",
+			in:      `<td style="text-align:left;background:url(javascript:x)">b</td><td style="color:red">c</td>`,
+			want:    `<td>b</td><td>c</td>`,
+			removed: []string{"style attribute on <td> (x2)"},
+		},
+		{
+			name:    "alignment style outside table cells",
+			in:      `<p style="text-align:center">x</p>`,
+			want:    `<p>x</p>`,
+			removed: []string{"style attribute on <p>"},
+		},
+		{
+			name: "text is escaped",
+			in:   `<p>1 &lt; 2 &amp; &lt;script&gt;</p>`,
+			want: `<p>1 &lt; 2 &amp; &lt;script&gt;</p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := policy.Sanitize(tt.in)
+			if got != tt.want {
+				t.Errorf("Sanitize(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+			if !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("Sanitize(%q) removed %q, want %q", tt.in, removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestSanitizeConfig(t *testing.T) {
+	policy := buildSanitizePolicy(SanitizeConfig{
+		Elements:   []string{"iframe"},
+		Attributes: map[string][]string{"iframe": {"src"}},
+		Schemes:    []string{"https"},
+	})
+
+	got, removed := policy.Sanitize(`<iframe src="https://example.com/embed"></iframe><a href="http://example.com/">x</a>`)
+	want := `<iframe src="https://example.com/embed"></iframe><a>x</a>`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if wantRemoved := []string{"disallowed URL in href on <a>"}; !reflect.DeepEqual(removed, wantRemoved) {
+		t.Errorf("removed %q, want %q", removed, wantRemoved)
+	}
+
+	if newSanitizePolicy(SanitizeConfig{}) != nil {
+		t.Error("newSanitizePolicy returned a policy while sanitizing is disabled")
+	}
+}
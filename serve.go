@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	liveReloadPath = "/_livereload"
+	watchInterval  = 500 * time.Millisecond
+)
+
+// liveReloadScript is injected into HTML pages served with --watch. The
+// browser reconnects on its own when the server restarts.
+const liveReloadScript = `<script>new EventSource("` + liveReloadPath + `").onmessage = function () { location.reload(); };</script>`
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	watch := fs.Bool("watch", false, "Rebuild on changes and reload connected browsers")
+	opts := buildFlags(fs)
+	fs.Parse(args)
+
+	site, err := loadConfig(opts.siteOptions)
+	if err != nil {
+		return err
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// A draft preview is built into the cache rather than the output
+	// directory, which is what gets deployed.
+	if opts.Drafts && opts.Destination == "" {
+		opts.Destination = filepath.Join(site.CacheDir, "preview")
+		site.OutputDir = opts.Destination
+	}
+
+	// A draft preview needs a build that includes them; otherwise serve
+	// whatever is already in the output directory.
+	if *watch || opts.Drafts {
+		if err := build(*opts); err != nil {
+			return err
+		}
+	}
+
+	var handler http.Handler = http.FileServer(http.Dir(site.OutputDir))
+	if *watch {
+		reloader := newLiveReloader()
+		go watchSite(site, *opts, reloader)
+
+		mux := http.NewServeMux()
+		mux.Handle(liveReloadPath, reloader)
+		mux.Handle("/", injectLiveReload(handler, site.OutputDir))
+		handler = mux
+	}
+
+	fmt.Printf("Serving %s on http://0.0.0.0:%s\n", site.OutputDir, port)
+	return http.ListenAndServe(":"+port, handler)
+}
+
+// watchSite polls the site sources and rebuilds whenever they change. A
+// failed build is reported and browsers are not reloaded; the next change
+// triggers another attempt.
+func watchSite(site SiteConfig, opts buildOptions, reloader *liveReloader) {
+	watched := []string{site.ContentDir, site.PagesDir, site.TemplateDir, site.StaticDir, opts.configPath()}
+	last := snapshotFiles(watched)
+
+	for {
+		time.Sleep(watchInterval)
+
+		current := snapshotFiles(watched)
+		if sameSnapshot(last, current) {
+			continue
+		}
+		last = current
+
+		fmt.Println("Change detected, rebuilding...")
+		if err := build(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			continue
+		}
+		reloader.Reload()
+	}
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshotFiles records the size and modification time of every file under
+// the given paths. Paths that do not exist are skipped.
+func snapshotFiles(paths []string) map[string]fileStamp {
+	snapshot := make(map[string]fileStamp)
+	for _, root := range paths {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			snapshot[path] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || other != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// liveReloader is a Server-Sent Events endpoint that tells every connected
+// browser to reload.
+type liveReloader struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+func newLiveReloader() *liveReloader {
+	return &liveReloader{clients: make(map[chan struct{}]bool)}
+}
+
+func (l *liveReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.clients[ch] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.clients, ch)
+		l.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	select {
+	case <-ch:
+		fmt.Fprint(w, "data: reload\n\n")
+		flusher.Flush()
+	case <-r.Context().Done():
+	}
+}
+
+func (l *liveReloader) Reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// injectLiveReload serves HTML pages from dir with the live reload script
+// appended to the body and hands everything else to next.
+func injectLiveReload(next http.Handler, dir string) http.Handler {
+	root := http.Dir(dir)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+		}
+		if !strings.HasSuffix(name, ".html") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		f, err := root.Open(name)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		page, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if i := bytes.LastIndex(page, []byte("</body>")); i >= 0 {
+			page = append(page[:i:i], append([]byte(liveReloadScript), page[i:]...)...)
+		} else {
+			page = append(page, liveReloadScript...)
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(page))
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type Sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+type SitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// generateSitemap lists the home page, the archive and every post and page
+// that has not opted out with `sitemap: false`.
+func generateSitemap(out *outputWriter, site SiteConfig, posts, pages []*Post) error {
+	var newest string
+	if len(posts) > 0 {
+		newest = posts[0].Date.Format("2006-01-02")
+	}
+
+	sitemap := Sitemap{
+		URLs: []SitemapURL{
+			{Loc: site.URL + "/", LastMod: newest},
+			{Loc: site.URL + "/archive/", LastMod: newest},
+		},
+	}
+	for _, post := range append(posts[:len(posts):len(posts)], pages...) {
+		if !post.Sitemap {
+			continue
+		}
+		entry := SitemapURL{Loc: site.URL + post.URL}
+		if !post.Date.IsZero() {
+			entry.LastMod = post.Date.Format("2006-01-02")
+		}
+		sitemap.URLs = append(sitemap.URLs, entry)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(sitemap); err != nil {
+		return fmt.Errorf("encoding sitemap: %w", err)
+	}
+	buf.WriteString("\n")
+
+	if err := out.WriteFile("sitemap.xml", buf.Bytes()); err != nil {
+		return err
+	}
+	fmt.Println("Generated: sitemap.xml")
+
+	// A robots.txt among the static files replaces the generated one.
+	if _, err := os.Stat(filepath.Join(site.StaticDir, "robots.txt")); err == nil {
+		return nil
+	}
+	robots := fmt.Sprintf("User-agent: *\nAllow: /\n\nSitemap: %s/sitemap.xml\n", site.URL)
+	if err := out.WriteFile("robots.txt", []byte(robots)); err != nil {
+		return err
+	}
+	fmt.Println("Generated: robots.txt")
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Taxonomy groups posts by the values of one frontmatter list, such as tags.
+type Taxonomy struct {
+	Name  string
+	Title string
+	URL   string
+	Terms []*Term
+}
+
+type Term struct {
+	Name    string
+	Slug    string
+	URL     string
+	FeedURL string
+	Posts   []*Post
+}
+
+type TaxonomyPage struct {
+	Site     SiteConfig
+	Taxonomy *Taxonomy
+}
+
+type TermPage struct {
+	Site     SiteConfig
+	Taxonomy *Taxonomy
+	Term     *Term
+}
+
+var taxonomyDefs = []struct {
+	name  string
+	title string
+	terms func(*Post) []string
+}{
+	{"tags", "Tags", func(p *Post) []string { return p.Tags }},
+	{"categories", "Categories", func(p *Post) []string { return p.Categories }},
+}
+
+func termURL(taxonomy, term string) string {
+	return "/" + taxonomy + "/" + url.PathEscape(termSlug(term)) + "/"
+}
+
+var (
+	nonWordPattern     = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	termSpacingPattern = regexp.MustCompile(`[\s_-]+`)
+)
+
+// termSlug is the directory a term is published in. Terms written without
+// ASCII letters or digits keep their own letters, as in /tags/日本語/, and
+// terms with no letters at all get a hash.
+func termSlug(name string) string {
+	if slug := slugify(name); slug != "" {
+		return slug
+	}
+	if slug := strings.Trim(nonWordPattern.ReplaceAllString(strings.ToLower(name), "-"), "-"); slug != "" {
+		return slug
+	}
+	return "term-" + hashBytes([]byte(name))[:8]
+}
+
+// termKey is how a term is written, ignoring case and the choice of spaces,
+// hyphens or underscores between words. Terms with the same key are one term.
+func termKey(name string) string {
+	return strings.Trim(termSpacingPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// buildTaxonomies collects the terms used by posts for every taxonomy. Terms
+// that differ only in case or word separators, such as "Static Sites" and
+// static-sites, are merged under the first spelling seen; different terms
+// that would share a slug, such as "C++" and "C", are an error. Posts keep
+// the order they are given in.
+func buildTaxonomies(posts []*Post) ([]*Taxonomy, error) {
+	taxonomies, clashes := collectTaxonomies(posts)
+	if len(clashes) > 0 {
+		lines := make([]string, len(clashes))
+		for i, c := range clashes {
+			lines[i] = c.String()
+		}
+		return nil, fmt.Errorf("terms sharing a URL; rename one of each pair:\n  %s", strings.Join(lines, "\n  "))
+	}
+	return taxonomies, nil
+}
+
+// collectTaxonomies does the work of buildTaxonomies, reporting terms that
+// clash instead of failing, so lint can list them with other problems.
+func collectTaxonomies(posts []*Post) ([]*Taxonomy, []lintProblem) {
+	var clashes []lintProblem
+	var taxonomies []*Taxonomy
+	for _, def := range taxonomyDefs {
+		tax := &Taxonomy{
+			Name:  def.name,
+			Title: def.title,
+			URL:   "/" + def.name + "/",
+		}
+
+		bySlug := make(map[string]*Term)
+		for _, post := range posts {
+			seen := make(map[string]bool)
+			for _, name := range def.terms(post) {
+				slug := termSlug(name)
+				term, ok := bySlug[slug]
+				if ok && termKey(name) != termKey(term.Name) {
+					msg := fmt.Sprintf("%s %q and %q in %s would both be published at %s", def.name, name, term.Name, term.Posts[0].Source, term.URL)
+					clashes = append(clashes, lintProblem{source: post.Source, msg: msg})
+					continue
+				}
+				if seen[slug] {
+					continue
+				}
+				seen[slug] = true
+
+				if !ok {
+					term = &Term{
+						Name:    name,
+						Slug:    slug,
+						URL:     tax.URL + url.PathEscape(slug) + "/",
+						FeedURL: tax.URL + url.PathEscape(slug) + "/feed.xml",
+					}
+					bySlug[slug] = term
+					tax.Terms = append(tax.Terms, term)
+				}
+				term.Posts = append(term.Posts, post)
+			}
+		}
+
+		sort.Slice(tax.Terms, func(i, j int) bool {
+			return strings.ToLower(tax.Terms[i].Name) < strings.ToLower(tax.Terms[j].Name)
+		})
+		taxonomies = append(taxonomies, tax)
+	}
+	return taxonomies, clashes
+}
+
+// generateTaxonomies writes the index, listing page and feed for every term.
+// Drafts appear on listing pages but stay out of term feeds unless
+// feedDrafts is set.
+func generateTaxonomies(out *outputWriter, templates map[string]*template.Template, site SiteConfig, posts []*Post, feedDrafts bool) error {
+	taxonomies, err := buildTaxonomies(posts)
+	if err != nil {
+		return err
+	}
+	for _, tax := range taxonomies {
+		if err := generateTaxonomyPage(out, templates, site, tax); err != nil {
+			return err
+		}
+
+		for _, term := range tax.Terms {
+			if err := generateTermPage(out, templates, site, tax, term); err != nil {
+				return err
+			}
+
+			rel := filepath.Join(tax.Name, term.Slug, "feed.xml")
+			title := fmt.Sprintf("%s: %s", site.Title, term.Name)
+			description := "Posts filed under " + term.Name
+			termPosts := term.Posts
+			if !feedDrafts {
+				termPosts = withoutDrafts(termPosts)
+			}
+			if err := writeRSSFeed(out, rel, title, site.URL+term.URL, description, site, termPosts); err != nil {
+				return fmt.Errorf("writing feed for %s %q: %w", tax.Name, term.Name, err)
+			}
+			fmt.Printf("Generated: %s/%s/feed.xml\n", tax.Name, term.Slug)
+		}
+	}
+	return nil
+}
+
+func generateTaxonomyPage(out *outputWriter, templates map[string]*template.Template, site SiteConfig, tax *Taxonomy) error {
+	t, ok := templates["taxonomy.html"]
+	if !ok {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, TaxonomyPage{Site: site, Taxonomy: tax}); err != nil {
+		return fmt.Errorf("executing taxonomy template for %s: %w", tax.Name, err)
+	}
+
+	if err := out.WriteFile(filepath.Join(tax.Name, "index.html"), buf.Bytes()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated: %s/index.html\n", tax.Name)
+	return nil
+}
+
+func generateTermPage(out *outputWriter, templates map[string]*template.Template, site SiteConfig, tax *Taxonomy, term *Term) error {
+	t, ok := templates["term.html"]
+	if !ok {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, TermPage{Site: site, Taxonomy: tax, Term: term}); err != nil {
+		return fmt.Errorf("executing term template for %s %q: %w", tax.Name, term.Name, err)
+	}
+
+	if err := out.WriteFile(filepath.Join(tax.Name, term.Slug, "index.html"), buf.Bytes()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated: %s/%s/index.html\n", tax.Name, term.Slug)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTermSlug(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Go", "go"},
+		{"Static Sites", "static-sites"},
+		{"C++", "c"},
+		{"日本語", "日本語"},
+		{"Café au lait", "caf-au-lait"},
+		{"Русский язык", "русский-язык"},
+	}
+	for _, tt := range tests {
+		if got := termSlug(tt.name); got != tt.want {
+			t.Errorf("termSlug(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if a, b := termSlug("+++"), termSlug("---"); a == "" || a == b {
+		t.Errorf("termSlug gave %q and %q for terms without letters; want distinct, non-empty slugs", a, b)
+	}
+}
+
+func TestBuildTaxonomies(t *testing.T) {
+	posts := []*Post{
+		{Source: "posts/a.md", Tags: []string{"Go", "Static Sites", "日本語"}},
+		{Source: "posts/b.md", Tags: []string{"go", "static-sites"}},
+	}
+	taxonomies, err := buildTaxonomies(posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	terms := make(map[string]*Term)
+	for _, term := range taxonomies[0].Terms {
+		terms[term.Name] = term
+	}
+	if len(terms) != 3 {
+		t.Fatalf("got %d tags, want 3: %v", len(terms), terms)
+	}
+	for _, name := range []string{"Go", "Static Sites"} {
+		if n := len(terms[name].Posts); n != 2 {
+			t.Errorf("tag %q has %d posts, want 2", name, n)
+		}
+	}
+	if term := terms["日本語"]; term == nil || term.Slug != "日本語" || term.URL != "/tags/%E6%97%A5%E6%9C%AC%E8%AA%9E/" {
+		t.Errorf("tag 日本語 = %+v, want slug 日本語 at an escaped URL", term)
+	}
+}
+
+func TestBuildTaxonomiesClash(t *testing.T) {
+	posts := []*Post{
+		{Source: "posts/a.md", Tags: []string{"C"}},
+		{Source: "posts/b.md", Tags: []string{"C++"}},
+	}
+	_, err := buildTaxonomies(posts)
+	if err == nil {
+		t.Fatal("buildTaxonomies merged C and C++ without an error")
+	}
+	for _, want := range []string{"posts/b.md", `"C++"`, `"C"`, "/tags/c/"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}